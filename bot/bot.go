@@ -45,11 +45,17 @@ func (s *Service) Start(handleMessage func(tgbotapi.Update, *tgbotapi.BotAPI)) e
 	log.Println("Bot is now listening for updates...")
 
 	for update := range updates {
-		if update.Message == nil {
+		if update.Message == nil && update.CallbackQuery == nil {
 			continue
 		}
 
-		log.Printf("Received message from user %s: %s", update.Message.From.UserName, update.Message.Text)
+		// Plain text is logged by the handler, which knows when a message is
+		// a workbook password that must not appear in the logs.
+		if update.Message != nil && update.Message.IsCommand() {
+			log.Printf("Received command from user %s: %s", update.Message.From.UserName, update.Message.Text)
+		} else if update.Message != nil {
+			log.Printf("Received message from user %s", update.Message.From.UserName)
+		}
 		handleMessage(update, bot)
 	}
 
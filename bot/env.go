@@ -0,0 +1,26 @@
+package bot
+
+import (
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	EnvFilePath     = ".env"
+	EnvBotTokenName = "TELEGRAM_BOT_TOKEN"
+)
+
+func LoadEnv() {
+	if err := godotenv.Load(EnvFilePath); err != nil {
+		log.Printf("No %s file loaded (%v). Using process environment.", EnvFilePath, err)
+		return
+	}
+
+	log.Printf("Environment loaded from %s", EnvFilePath)
+}
+
+func GetBotToken() string {
+	return os.Getenv(EnvBotTokenName)
+}
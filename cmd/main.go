@@ -6,6 +6,9 @@ import (
 	"example/hello/logger"
 	"log"
 	"time"
+
+	// Registers the "sqlserver" driver used when DB_DRIVER is set.
+	_ "github.com/microsoft/go-mssqldb"
 )
 
 const (
@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/extrame/xls"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	FormatAnnotated = "annotated"
+
+	annotationAuthor    = "XLS Reader Bot"
+	reportSheetName     = "Extraction report"
+	matchHighlightColor = "FFF2CC"
+	skipHighlightColor  = "F8CBAD"
+)
+
+// AnnotatedWriter returns a copy of the uploaded workbook with matched cells
+// highlighted and commented, plus a sheet listing every match and skipped
+// row. Legacy .xls sources are copied value by value into a new workbook,
+// since excelize can only edit OOXML files.
+type AnnotatedWriter struct{}
+
+func (AnnotatedWriter) Name() string     { return FormatAnnotated }
+func (AnnotatedWriter) FileName() string { return "annotated.xlsx" }
+
+func (AnnotatedWriter) Write(w io.Writer, result *ExtractionResult) error {
+	f, err := openAnnotationCopy(result.SourcePath, result.password)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	styles := newHighlightStyles(f)
+
+	for _, match := range result.Matches {
+		cells := append([]string{match.Cell}, match.ValueCells...)
+		for _, cell := range cells {
+			styles.highlight(match.Sheet, cell, matchHighlightColor)
+		}
+		addAnnotation(f, match.Sheet, match.Cell, "Extracted contract: "+match.Number())
+	}
+
+	for _, skipped := range result.Skipped {
+		styles.highlight(skipped.Sheet, skipped.Cell, skipHighlightColor)
+		addAnnotation(f, skipped.Sheet, skipped.Cell, "Skipped: "+skipped.Reason)
+	}
+
+	if err := writeExtractionReport(f, result); err != nil {
+		return err
+	}
+
+	return f.Write(w)
+}
+
+// openAnnotationCopy opens the source for annotation. An encrypted source
+// is opened with its password, and excelize encrypts the copy with it again.
+// Macro-enabled workbooks and templates are saved as a regular workbook,
+// since the copy is sent as annotated.xlsx; their VBA project is removed,
+// as Excel refuses an .xlsx that carries one.
+func openAnnotationCopy(sourcePath, password string) (*excelize.File, error) {
+	if sourcePath == "" {
+		return nil, fmt.Errorf("no source workbook to annotate")
+	}
+
+	if workbookKindOf(sourcePath) != kindXLS {
+		pkg, err := os.ReadFile(sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open Excel file: %w", err)
+		}
+		if password != "" && bytes.HasPrefix(pkg, ole2Signature) {
+			if pkg, err = excelize.Decrypt(pkg, &excelize.Options{Password: password}); err != nil {
+				return nil, fmt.Errorf("failed to open Excel file: %w", err)
+			}
+		}
+		if pkg, err = stripVBAProject(pkg); err != nil {
+			return nil, fmt.Errorf("failed to open Excel file: %w", err)
+		}
+
+		f, err := excelize.OpenReader(bytes.NewReader(pkg), excelize.Options{Password: password})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open Excel file: %w", err)
+		}
+		f.Path = AnnotatedWriter{}.FileName()
+		return f, nil
+	}
+
+	xlsFile, err := xls.Open(sourcePath, "utf-8")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLS file: %w", err)
+	}
+
+	cellReader, err := newXlsCellReader(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLS file: %w", err)
+	}
+
+	f := excelize.NewFile()
+	for sheetIndex := 0; sheetIndex < xlsFile.NumSheets(); sheetIndex++ {
+		sheet := xlsFile.GetSheet(sheetIndex)
+		if sheet == nil {
+			continue
+		}
+
+		if sheetIndex == 0 {
+			f.SetSheetName("Sheet1", sheet.Name)
+		} else if _, err := f.NewSheet(sheet.Name); err != nil {
+			log.Printf("Error copying sheet %s: %v", sheet.Name, err)
+			continue
+		}
+
+		cells := cellReader.sheetCells(sheetIndex)
+		for rowIndex := 0; rowIndex <= int(sheet.MaxRow); rowIndex++ {
+			row := sheet.Row(rowIndex)
+			if row == nil {
+				continue
+			}
+			for colIndex, value := range cells.rowValues(rowIndex, row) {
+				if value == "" {
+					continue
+				}
+				cell, _ := excelize.CoordinatesToCellName(colIndex+1, rowIndex+1)
+				f.SetCellValue(sheet.Name, cell, value)
+			}
+		}
+	}
+
+	return f, nil
+}
+
+var (
+	vbaRelationship  = regexp.MustCompile(`<Relationship\b[^>]*\bType=["'][^"']*/vbaProject["'][^>]*(?:/>|>\s*</Relationship>)`)
+	vbaContentType   = regexp.MustCompile(`<Override\b[^>]*\bPartName=["']/xl/vbaProject[^"']*["'][^>]*(?:/>|>\s*</Override>)`)
+	macroContentType = regexp.MustCompile(`application/vnd\.ms-excel\.(sheet|template)\.macroEnabled\.main\+xml`)
+)
+
+// stripVBAProject removes the VBA project from an OOXML package: its parts,
+// the workbook's relationship to it and its content types, and it turns a
+// macro-enabled workbook into a regular one. Packages without a VBA project
+// are returned unchanged.
+func stripVBAProject(pkg []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(pkg), int64(len(pkg)))
+	if err != nil {
+		return nil, err
+	}
+
+	isVBAPart := func(name string) bool {
+		return strings.HasPrefix(name, "xl/vbaProject") || strings.HasPrefix(name, "xl/_rels/vbaProject")
+	}
+	hasVBA := false
+	for _, file := range zr.File {
+		hasVBA = hasVBA || isVBAPart(file.Name)
+	}
+	if !hasVBA {
+		return pkg, nil
+	}
+
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for _, file := range zr.File {
+		if isVBAPart(file.Name) {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		switch file.Name {
+		case "xl/_rels/workbook.xml.rels":
+			content = vbaRelationship.ReplaceAll(content, nil)
+		case "[Content_Types].xml":
+			content = vbaContentType.ReplaceAll(content, nil)
+			content = macroContentType.ReplaceAll(content, []byte(excelize.ContentTypeSheetML))
+		}
+
+		part, err := zw.Create(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// highlightStyles derives fill styles from the cells' existing styles, so
+// borders and number formats survive, and reuses them across cells.
+type highlightStyles struct {
+	f      *excelize.File
+	cached map[string]int
+}
+
+func newHighlightStyles(f *excelize.File) *highlightStyles {
+	return &highlightStyles{f: f, cached: make(map[string]int)}
+}
+
+func (s *highlightStyles) highlight(sheet, cell, color string) {
+	baseID, err := s.f.GetCellStyle(sheet, cell)
+	if err != nil {
+		log.Printf("Error reading style of %s!%s: %v", sheet, cell, err)
+		return
+	}
+
+	key := fmt.Sprintf("%d/%s", baseID, color)
+	styleID, exists := s.cached[key]
+	if !exists {
+		style, err := s.f.GetStyle(baseID)
+		if err != nil || style == nil {
+			style = &excelize.Style{}
+		}
+		style.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{color}}
+
+		styleID, err = s.f.NewStyle(style)
+		if err != nil {
+			log.Printf("Error creating highlight style: %v", err)
+			return
+		}
+		s.cached[key] = styleID
+	}
+
+	if err := s.f.SetCellStyle(sheet, cell, cell, styleID); err != nil {
+		log.Printf("Error highlighting %s!%s: %v", sheet, cell, err)
+	}
+}
+
+func addAnnotation(f *excelize.File, sheet, cell, text string) {
+	err := f.AddComment(sheet, excelize.Comment{
+		Author: annotationAuthor,
+		Cell:   cell,
+		Text:   text,
+	})
+	if err != nil {
+		log.Printf("Error adding comment to %s!%s: %v", sheet, cell, err)
+	}
+}
+
+func writeExtractionReport(f *excelize.File, result *ExtractionResult) error {
+	sheetName := reportSheetName
+	for suffix := 2; ; suffix++ {
+		if index, _ := f.GetSheetIndex(sheetName); index == -1 {
+			break
+		}
+		sheetName = fmt.Sprintf("%s (%d)", reportSheetName, suffix)
+	}
+
+	if _, err := f.NewSheet(sheetName); err != nil {
+		return fmt.Errorf("failed to create report sheet: %w", err)
+	}
+
+	header := []string{"status", "number", "sheet", "row", "cell", "reason"}
+	if err := f.SetSheetRow(sheetName, "A1", &header); err != nil {
+		return fmt.Errorf("failed to write report header: %w", err)
+	}
+
+	rowIndex := 2
+	writeRow := func(values []any, sheet, cell string) error {
+		start, _ := excelize.CoordinatesToCellName(1, rowIndex)
+		if err := f.SetSheetRow(sheetName, start, &values); err != nil {
+			return fmt.Errorf("failed to write report row %d: %w", rowIndex, err)
+		}
+
+		link, _ := excelize.CoordinatesToCellName(5, rowIndex)
+		location := fmt.Sprintf("'%s'!%s", strings.ReplaceAll(sheet, "'", "''"), cell)
+		if err := f.SetCellHyperLink(sheetName, link, location, "Location"); err != nil {
+			log.Printf("Error linking report row %d: %v", rowIndex, err)
+		}
+
+		rowIndex++
+		return nil
+	}
+
+	for _, match := range result.Matches {
+		if err := writeRow([]any{"matched", match.Number(), match.Sheet, match.Row, match.Cell, ""}, match.Sheet, match.Cell); err != nil {
+			return err
+		}
+	}
+	for _, skipped := range result.Skipped {
+		if err := writeRow([]any{"skipped", "", skipped.Sheet, skipped.Row, skipped.Cell, skipped.Reason}, skipped.Sheet, skipped.Cell); err != nil {
+			return err
+		}
+	}
+
+	if err := f.SetColWidth(sheetName, "B", "B", 24); err != nil {
+		return fmt.Errorf("failed to set column width: %w", err)
+	}
+
+	return nil
+}
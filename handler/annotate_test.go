@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestAnnotatedWriter(t *testing.T) {
+	h := NewHandler()
+
+	src := excelize.NewFile()
+	src.SetCellValue("Sheet1", "A2", "ББС ІНШУРАНС")
+	src.SetCellValue("Sheet1", "B2", "228960453")
+	src.SetCellValue("Sheet1", "C2", "123")
+	src.SetCellValue("Sheet1", "A3", "ББС ІНШУРАНС")
+
+	sourcePath := filepath.Join(t.TempDir(), "source.xlsx")
+	if err := src.SaveAs(sourcePath); err != nil {
+		t.Fatalf("Failed to save test file: %v", err)
+	}
+	src.Close()
+
+	result, err := h.readExcelFile(sourcePath, h.defaultRule())
+	if err != nil {
+		t.Fatalf("readExcelFile failed: %v", err)
+	}
+
+	content, err := renderOutput(AnnotatedWriter{}, result)
+	if err != nil {
+		t.Fatalf("renderOutput failed: %v", err)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Output is not a valid workbook: %v", err)
+	}
+	defer f.Close()
+
+	t.Run("matched cells are highlighted", func(t *testing.T) {
+		for _, cell := range []string{"A2", "B2", "C2"} {
+			styleID, _ := f.GetCellStyle("Sheet1", cell)
+			style, err := f.GetStyle(styleID)
+			if err != nil || len(style.Fill.Color) == 0 || style.Fill.Color[0] != matchHighlightColor {
+				t.Errorf("Cell %s should be highlighted, got style %+v", cell, style)
+			}
+		}
+	})
+
+	t.Run("comments note contract and skip reason", func(t *testing.T) {
+		comments, err := f.GetComments("Sheet1")
+		if err != nil {
+			t.Fatalf("GetComments failed: %v", err)
+		}
+
+		texts := make(map[string]string)
+		for _, comment := range comments {
+			texts[comment.Cell] = comment.Text
+		}
+		if !strings.Contains(texts["A2"], "EP-228960453-123") {
+			t.Errorf("A2 comment = %q, should name the contract", texts["A2"])
+		}
+		if !strings.Contains(texts["A3"], SkipReasonMissingValues) {
+			t.Errorf("A3 comment = %q, should give the skip reason", texts["A3"])
+		}
+	})
+
+	t.Run("report sheet lists matches and skipped rows", func(t *testing.T) {
+		rows, err := f.GetRows(reportSheetName)
+		if err != nil {
+			t.Fatalf("Report sheet missing: %v", err)
+		}
+		if len(rows) != 3 {
+			t.Fatalf("Expected header and 2 rows, got %d", len(rows))
+		}
+		if rows[1][0] != "matched" || rows[1][1] != "EP-228960453-123" {
+			t.Errorf("Unexpected match row: %v", rows[1])
+		}
+		if rows[2][0] != "skipped" || rows[2][5] != SkipReasonMissingValues {
+			t.Errorf("Unexpected skipped row: %v", rows[2])
+		}
+	})
+
+	t.Run("original data is preserved", func(t *testing.T) {
+		value, _ := f.GetCellValue("Sheet1", "B2")
+		if value != "228960453" {
+			t.Errorf("B2 = %q, want original value", value)
+		}
+	})
+}
+
+func TestAnnotatedWriter_MacroEnabledSource(t *testing.T) {
+	h := NewHandler()
+
+	for _, password := range []string{"", "secret"} {
+		src := excelize.NewFile()
+		src.SetCellValue("Sheet1", "A1", DefaultTargetText)
+		src.SetCellValue("Sheet1", "B1", "228960453")
+		src.SetCellValue("Sheet1", "C1", "123")
+		if err := src.AddVBAProject(append(append([]byte{}, ole2Signature...), make([]byte, 512)...)); err != nil {
+			t.Fatalf("AddVBAProject failed: %v", err)
+		}
+
+		sourcePath := filepath.Join(t.TempDir(), "macros.xlsm")
+		if err := src.SaveAs(sourcePath, excelize.Options{Password: password}); err != nil {
+			t.Fatalf("Failed to save test file: %v", err)
+		}
+		src.Close()
+
+		result, err := h.readWorkbook(sourcePath, h.defaultRule(), password)
+		if err != nil {
+			t.Fatalf("readWorkbook failed: %v", err)
+		}
+		content, err := renderOutput(AnnotatedWriter{}, result)
+		if err != nil {
+			t.Fatalf("renderOutput failed: %v", err)
+		}
+
+		if password != "" {
+			if content, err = excelize.Decrypt(content, &excelize.Options{Password: password}); err != nil {
+				t.Fatalf("The copy should stay encrypted with the source password: %v", err)
+			}
+		}
+
+		parts := readZipParts(t, content)
+		if _, found := parts["xl/vbaProject.bin"]; found {
+			t.Error("The copy should not contain the VBA project")
+		}
+		if strings.Contains(parts["xl/_rels/workbook.xml.rels"], "vbaProject") {
+			t.Errorf("The workbook should not refer to the VBA project:\n%s", parts["xl/_rels/workbook.xml.rels"])
+		}
+		contentTypes := parts["[Content_Types].xml"]
+		if strings.Contains(contentTypes, "macroEnabled") || !strings.Contains(contentTypes, excelize.ContentTypeSheetML) {
+			t.Errorf("The workbook should have the regular workbook content type:\n%s", contentTypes)
+		}
+
+		f, err := excelize.OpenReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Output is not a valid workbook: %v", err)
+		}
+		if comments, _ := f.GetComments("Sheet1"); len(comments) != 1 {
+			t.Errorf("Expected the match to be annotated, got %d comments", len(comments))
+		}
+		f.Close()
+	}
+}
+
+func readZipParts(t *testing.T, content []byte) map[string]string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("Output is not a ZIP package: %v", err)
+	}
+
+	parts := make(map[string]string)
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file.Name, err)
+		}
+		parts[file.Name] = string(data)
+	}
+	return parts
+}
+
+// Excel writes self-closing elements, unlike excelize.
+func TestStripVBAProject(t *testing.T) {
+	var pkg bytes.Buffer
+	zw := zip.NewWriter(&pkg)
+	for name, content := range map[string]string{
+		"[Content_Types].xml": `<Types><Default Extension="bin" ContentType="application/vnd.ms-office.vbaProject"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.ms-excel.sheet.macroEnabled.main+xml"/>` +
+			`<Override PartName="/xl/vbaProjectSignature.bin" ContentType="application/vnd.ms-office.vbaProjectSignature"/></Types>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.microsoft.com/office/2006/relationships/vbaProject" Target="vbaProject.bin"/></Relationships>`,
+		"xl/vbaProject.bin":            "vba",
+		"xl/vbaProjectSignature.bin":   "signature",
+		"xl/_rels/vbaProject.bin.rels": "<Relationships/>",
+		"xl/worksheets/sheet1.xml":     "<worksheet/>",
+	} {
+		part, _ := zw.Create(name)
+		part.Write([]byte(content))
+	}
+	zw.Close()
+
+	stripped, err := stripVBAProject(pkg.Bytes())
+	if err != nil {
+		t.Fatalf("stripVBAProject failed: %v", err)
+	}
+
+	parts := readZipParts(t, stripped)
+	for _, name := range []string{"xl/vbaProject.bin", "xl/vbaProjectSignature.bin", "xl/_rels/vbaProject.bin.rels"} {
+		if _, found := parts[name]; found {
+			t.Errorf("%s should be removed", name)
+		}
+	}
+	if rels := parts["xl/_rels/workbook.xml.rels"]; strings.Contains(rels, "vbaProject") || !strings.Contains(rels, `Id="rId1"`) {
+		t.Errorf("only the VBA relationship should be removed:\n%s", rels)
+	}
+	if types := parts["[Content_Types].xml"]; strings.Contains(types, "vbaProjectSignature") || !strings.Contains(types, excelize.ContentTypeSheetML) {
+		t.Errorf("unexpected content types:\n%s", types)
+	}
+	if parts["xl/worksheets/sheet1.xml"] != "<worksheet/>" {
+		t.Error("other parts should be copied unchanged")
+	}
+
+	if again, err := stripVBAProject(stripped); err != nil || !bytes.Equal(again, stripped) {
+		t.Error("a package without a VBA project should be returned unchanged")
+	}
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const archiveExtension = ".zip"
+
+// ErrArchiveLimit is returned for archives that exceed the configured entry
+// count, total size or compression ratio, which protects against zip bombs.
+var ErrArchiveLimit = errors.New("archive exceeds limits")
+
+func isArchiveFile(fileName string) bool {
+	return strings.EqualFold(filepath.Ext(fileName), archiveExtension)
+}
+
+func verifyArchiveSignature(filePath, fileName string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(zipSignature))
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.Equal(header, zipSignature) {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, fileName)
+	}
+	return nil
+}
+
+// archiveDir is where the spreadsheets of a stored archive are extracted.
+func archiveDir(archivePath string) string {
+	return strings.TrimSuffix(archivePath, filepath.Ext(archivePath)) + "_files"
+}
+
+// extractArchive unpacks the spreadsheets of a ZIP archive into destDir.
+// Other entries, folders and macOS metadata are ignored. The declared sizes
+// are checked before anything is written and the actual sizes while
+// copying, since a crafted archive can declare anything.
+func (h *Handler) extractArchive(archivePath, destDir string) ([]batchEntry, error) {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer archive.Close()
+
+	var files []*zip.File
+	for _, file := range archive.File {
+		name := path.Base(file.Name)
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !hasExtension(name, workbookExtensions) && !hasExtension(name, binaryWorkbookExtensions) {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	maxEntries := h.config.ArchiveMaxEntries
+	if len(files) > maxEntries {
+		return nil, fmt.Errorf("%w: %d spreadsheets, at most %d allowed", ErrArchiveLimit, len(files), maxEntries)
+	}
+
+	var declared uint64
+	for _, file := range files {
+		declared += file.UncompressedSize64
+		if err := h.checkArchiveRatio(file.Name, file.UncompressedSize64, file.CompressedSize64); err != nil {
+			return nil, err
+		}
+	}
+	if declared > uint64(h.config.ArchiveMaxSize) {
+		return nil, fmt.Errorf("%w: %d bytes uncompressed, at most %d allowed", ErrArchiveLimit, declared, h.config.ArchiveMaxSize)
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	entries := make([]batchEntry, 0, len(files))
+	remaining := h.config.ArchiveMaxSize
+	for i, file := range files {
+		name := path.Base(file.Name)
+		// The index keeps entries with the same name in different folders
+		// apart; the base name keeps the path inside destDir.
+		entryPath := filepath.Join(destDir, fmt.Sprintf("%02d_%s", i+1, name))
+
+		written, err := extractArchiveFile(file, entryPath, remaining)
+		if err != nil {
+			return nil, err
+		}
+		remaining -= written
+		if err := h.checkArchiveRatio(file.Name, uint64(written), file.CompressedSize64); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, batchEntry{Name: name, Path: entryPath})
+	}
+
+	return entries, nil
+}
+
+func (h *Handler) checkArchiveRatio(name string, uncompressed, compressed uint64) error {
+	if compressed == 0 {
+		compressed = 1
+	}
+	if ratio := uncompressed / compressed; ratio > uint64(h.config.ArchiveMaxRatio) {
+		return fmt.Errorf("%w: %s is compressed %d:1, at most %d:1 allowed", ErrArchiveLimit, name, ratio, h.config.ArchiveMaxRatio)
+	}
+	return nil
+}
+
+// extractArchiveFile copies one entry, failing once more than limit bytes
+// have been written.
+func extractArchiveFile(file *zip.File, destPath string, limit int64) (int64, error) {
+	src, err := file.Open()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s in archive: %w", file.Name, err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", destPath, err)
+	}
+
+	written, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	closeErr := dst.Close()
+	if err != nil {
+		return written, fmt.Errorf("failed to extract %s: %w", file.Name, err)
+	}
+	if closeErr != nil {
+		return written, fmt.Errorf("failed to extract %s: %w", file.Name, closeErr)
+	}
+	if written > limit {
+		return written, fmt.Errorf("%w: more than %d bytes uncompressed", ErrArchiveLimit, limit)
+	}
+
+	return written, nil
+}
+
+// processArchive extracts every spreadsheet of an uploaded archive with the
+// upload's rule and sends one combined output together with a summary per
+// file.
+func (h *Handler) processArchive(bot *tgbotapi.BotAPI, upload HistoryRecord) {
+	chatID := upload.ChatID
+
+	writer := h.batchWriter(upload.Template)
+	upload.Template = writer.Name()
+
+	rule := h.uploadRule(upload)
+	upload.Rule = rule.Name
+
+	entries, err := h.extractArchive(upload.FilePath, archiveDir(upload.FilePath))
+	if err != nil {
+		log.Printf("Error extracting archive %s: %v", upload.FilePath, err)
+		text := TextArchiveReadError
+		if errors.Is(err, ErrArchiveLimit) {
+			text = fmt.Sprintf(TextArchiveLimit, err)
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
+		bot.Send(msg)
+		return
+	}
+
+	if len(entries) == 0 {
+		msg := tgbotapi.NewMessage(chatID, TextArchiveEmpty)
+		bot.Send(msg)
+		return
+	}
+
+	h.readBatchEntries(entries, rule)
+	result := combineBatchResults(upload.FilePath, rule, entries)
+	summary := formatBatchSummary(fmt.Sprintf(TextArchiveHeader, len(entries)), entries, result)
+
+	resultCount, outputPath, ok := h.sendExtraction(bot, chatID, upload.FilePath, summary, result, writer)
+	if !ok {
+		return
+	}
+
+	upload.ResultCount = resultCount
+	upload.OutputPath = outputPath
+	h.recordHistory(upload)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func newTestArchiveHandler(t *testing.T) *Handler {
+	cfg := DefaultConfig()
+	cfg.FilesDir = t.TempDir()
+	return NewHandlerWithConfig(cfg)
+}
+
+func registerBytes(t *testing.T, series, number string) []byte {
+	t.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+	f.SetCellValue("Sheet1", "A1", DefaultTargetText)
+	f.SetCellValue("Sheet1", "B1", series)
+	f.SetCellValue("Sheet1", "C1", number)
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatalf("failed to write workbook: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// writeArchive stores the given entries in a ZIP file, in name order.
+func writeArchive(t *testing.T, entries map[string][]byte, names ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "registers.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for _, name := range names {
+		part, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		part.Write(entries[name])
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	return path
+}
+
+func TestExtractArchive(t *testing.T) {
+	h := newTestArchiveHandler(t)
+	path := writeArchive(t, map[string][]byte{
+		"kyiv/register.xlsx":            registerBytes(t, "228960453", "123"),
+		"lviv/register.xlsx":            registerBytes(t, "228960454", "456"),
+		"readme.txt":                    []byte("not a spreadsheet"),
+		"__MACOSX/kyiv/._register.xlsx": []byte("metadata"),
+	}, "kyiv/register.xlsx", "lviv/register.xlsx", "readme.txt", "__MACOSX/kyiv/._register.xlsx")
+
+	entries, err := h.extractArchive(path, archiveDir(path))
+	if err != nil {
+		t.Fatalf("extractArchive failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 spreadsheets, got %d", len(entries))
+	}
+	if entries[0].Path == entries[1].Path {
+		t.Error("Entries with the same name should be extracted to different files")
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Path, archiveDir(path)) {
+			t.Errorf("Entry %s extracted outside the archive directory: %s", entry.Name, entry.Path)
+		}
+		entry.Result, entry.Err = h.readExcelFile(entry.Path, h.defaultRule())
+		if entry.Err != nil || len(entry.Result.Matches) != 1 {
+			t.Errorf("Entry %s: unexpected result %v, %v", entry.Name, entry.Result, entry.Err)
+		}
+	}
+}
+
+func TestExtractArchive_Limits(t *testing.T) {
+	workbook := registerBytes(t, "228960453", "123")
+
+	t.Run("too many entries", func(t *testing.T) {
+		h := newTestArchiveHandler(t)
+		h.config.ArchiveMaxEntries = 1
+		path := writeArchive(t, map[string][]byte{"a.xlsx": workbook, "b.xlsx": workbook}, "a.xlsx", "b.xlsx")
+
+		if _, err := h.extractArchive(path, archiveDir(path)); !errors.Is(err, ErrArchiveLimit) {
+			t.Errorf("Expected ErrArchiveLimit, got: %v", err)
+		}
+	})
+
+	t.Run("total size", func(t *testing.T) {
+		h := newTestArchiveHandler(t)
+		h.config.ArchiveMaxSize = int64(len(workbook)) + 1
+		path := writeArchive(t, map[string][]byte{"a.xlsx": workbook, "b.xlsx": workbook}, "a.xlsx", "b.xlsx")
+
+		if _, err := h.extractArchive(path, archiveDir(path)); !errors.Is(err, ErrArchiveLimit) {
+			t.Errorf("Expected ErrArchiveLimit, got: %v", err)
+		}
+	})
+
+	t.Run("compression ratio", func(t *testing.T) {
+		h := newTestArchiveHandler(t)
+		bomb := bytes.Repeat([]byte{0}, 4*1024*1024)
+		path := writeArchive(t, map[string][]byte{"bomb.xlsx": bomb}, "bomb.xlsx")
+
+		_, err := h.extractArchive(path, archiveDir(path))
+		if !errors.Is(err, ErrArchiveLimit) {
+			t.Errorf("Expected ErrArchiveLimit, got: %v", err)
+		}
+		if _, statErr := os.Stat(archiveDir(path)); statErr == nil {
+			t.Error("Nothing should be extracted from a rejected archive")
+		}
+	})
+}
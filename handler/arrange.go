@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Duplicate handling: keep every match, or only the first or last match of
+// each contract number.
+const (
+	DuplicatesKeep  = "keep"
+	DuplicatesFirst = "first"
+	DuplicatesLast  = "last"
+)
+
+// Output order: as found in the file, or by contract number.
+const (
+	OrderFile   = "file"
+	OrderNumber = "number"
+)
+
+func validateArrangement(duplicates, order string) error {
+	switch duplicates {
+	case "", DuplicatesKeep, DuplicatesFirst, DuplicatesLast:
+	default:
+		return fmt.Errorf("unknown duplicates option %q", duplicates)
+	}
+	switch order {
+	case "", OrderFile, OrderNumber:
+	default:
+		return fmt.Errorf("unknown order %q", order)
+	}
+	return nil
+}
+
+// requestArrangement reads "duplicates=<keep|first|last>" and
+// "order=<file|number>" from a caption or command arguments. Unknown values
+// are ignored.
+func requestArrangement(requestText string) (duplicates, order string) {
+	for _, word := range strings.Fields(strings.ToLower(requestText)) {
+		key, value, found := strings.Cut(word, "=")
+		if !found {
+			continue
+		}
+		switch {
+		case key == "duplicates" && validateArrangement(value, "") == nil:
+			duplicates = value
+		case key == "order" && validateArrangement("", value) == nil:
+			order = value
+		}
+	}
+	return duplicates, order
+}
+
+// arrange drops duplicate contract numbers and orders the matches. The
+// output writers number the rows in this order, so the VALUES list and its
+// ORDER BY follow it as well.
+func (r *ExtractionResult) arrange(duplicates, order string) {
+	found := len(r.Matches)
+	switch duplicates {
+	case DuplicatesFirst:
+		r.Matches = uniqueMatches(r.Matches)
+	case DuplicatesLast:
+		slices.Reverse(r.Matches)
+		r.Matches = uniqueMatches(r.Matches)
+		slices.Reverse(r.Matches)
+	}
+	r.DuplicatesRemoved += found - len(r.Matches)
+
+	if order == OrderNumber {
+		slices.SortStableFunc(r.Matches, func(a, b ContractMatch) int {
+			return compareContractNumbers(a.Number(), b.Number())
+		})
+	}
+}
+
+// uniqueMatches keeps the first match of every number, reusing the slice.
+func uniqueMatches(matches []ContractMatch) []ContractMatch {
+	seen := make(map[string]bool, len(matches))
+	unique := matches[:0]
+	for _, match := range matches {
+		if seen[match.Number()] {
+			continue
+		}
+		seen[match.Number()] = true
+		unique = append(unique, match)
+	}
+	return unique
+}
+
+// compareContractNumbers orders numbers naturally, comparing runs of digits
+// by value so that "EP-9-1" comes before "EP-10-1".
+func compareContractNumbers(a, b string) int {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aValue, bValue := strings.TrimLeft(aDigits, "0"), strings.TrimLeft(bDigits, "0")
+			if c := len(aValue) - len(bValue); c != 0 {
+				return c
+			}
+			if c := strings.Compare(aValue, bValue); c != 0 {
+				return c
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+
+		if c := strings.Compare(a[:1], b[:1]); c != 0 {
+			return c
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+func leadingDigits(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) || r > unicode.MaxASCII })
+	if end == -1 {
+		return s
+	}
+	return s[:end]
+}
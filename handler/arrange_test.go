@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestArrangeMatches(t *testing.T) {
+	rule := builtinRule("")
+	rows := [][]string{
+		{DefaultTargetText, "228960453", "10"},
+		{DefaultTargetText, "228960453", "9"},
+		{DefaultTargetText, "228960453", "10"},
+		{DefaultTargetText, "128960453", "1"},
+	}
+
+	tests := []struct {
+		duplicates, order string
+		wantNumbers       []string
+		wantRows          []int
+		wantRemoved       int
+	}{
+		{"", "", []string{"EP-228960453-10", "EP-228960453-9", "EP-228960453-10", "EP-128960453-1"}, []int{1, 2, 3, 4}, 0},
+		{DuplicatesFirst, OrderFile, []string{"EP-228960453-10", "EP-228960453-9", "EP-128960453-1"}, []int{1, 2, 4}, 1},
+		{DuplicatesLast, OrderFile, []string{"EP-228960453-9", "EP-228960453-10", "EP-128960453-1"}, []int{2, 3, 4}, 1},
+		{DuplicatesKeep, OrderNumber, []string{"EP-128960453-1", "EP-228960453-9", "EP-228960453-10", "EP-228960453-10"}, []int{4, 2, 1, 3}, 0},
+		{DuplicatesLast, OrderNumber, []string{"EP-128960453-1", "EP-228960453-9", "EP-228960453-10"}, []int{4, 2, 3}, 1},
+	}
+
+	for _, tt := range tests {
+		result := newExtractionResult("test.xlsx", rule)
+		result.beginSheet("Sheet1")
+		for i, row := range rows {
+			result.scanRow(i, row)
+		}
+
+		result.arrange(tt.duplicates, tt.order)
+
+		var rowNumbers []int
+		for _, match := range result.Matches {
+			rowNumbers = append(rowNumbers, match.Row)
+		}
+		if got := result.Numbers(); !slices.Equal(got, tt.wantNumbers) {
+			t.Errorf("%s/%s: numbers = %v, want %v", tt.duplicates, tt.order, got, tt.wantNumbers)
+		}
+		if !slices.Equal(rowNumbers, tt.wantRows) {
+			t.Errorf("%s/%s: rows = %v, want %v", tt.duplicates, tt.order, rowNumbers, tt.wantRows)
+		}
+		if result.DuplicatesRemoved != tt.wantRemoved {
+			t.Errorf("%s/%s: DuplicatesRemoved = %d, want %d", tt.duplicates, tt.order, result.DuplicatesRemoved, tt.wantRemoved)
+		}
+	}
+}
+
+func TestArrangeMatches_SQLOrder(t *testing.T) {
+	result := newExtractionResult("test.xlsx", builtinRule(""))
+	result.beginSheet("Sheet1")
+	result.scanRow(0, []string{DefaultTargetText, "B2", "1"})
+	result.scanRow(1, []string{DefaultTargetText, "A1", "1"})
+	result.arrange(DuplicatesKeep, OrderNumber)
+
+	script := buildSQLScript(result.Numbers())
+	if !strings.Contains(script, "('EP-A1-1', 0)") || !strings.Contains(script, "('EP-B2-1', 1)") {
+		t.Errorf("VALUES do not follow the number order:\n%s", script)
+	}
+}
+
+func TestCompareContractNumbers(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"EP-9-1", "EP-10-1", -1},
+		{"EP-10-1", "EP-9-1", 1},
+		{"EP-009-1", "EP-9-1", 0},
+		{"EP-A1", "EP-B1", -1},
+		{"EP-1", "EP-1-2", -1},
+		{"EP-1", "EP-1", 0},
+	}
+
+	for _, tt := range tests {
+		got := compareContractNumbers(tt.a, tt.b)
+		if (got < 0) != (tt.want < 0) || (got > 0) != (tt.want > 0) {
+			t.Errorf("compareContractNumbers(%q, %q) = %d, want sign of %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRequestArrangement(t *testing.T) {
+	duplicates, order := requestArrangement("csv Duplicates=LAST order=number")
+	if duplicates != DuplicatesLast || order != OrderNumber {
+		t.Errorf("got %q, %q", duplicates, order)
+	}
+
+	duplicates, order = requestArrangement("duplicates=some order=random")
+	if duplicates != "" || order != "" {
+		t.Errorf("invalid values should be ignored, got %q, %q", duplicates, order)
+	}
+}
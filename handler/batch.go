@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// batchEntry is one spreadsheet of a multi-file upload, such as an archive
+// or a Telegram media group: where it is stored and what reading it
+// produced.
+type batchEntry struct {
+	Name   string
+	Path   string
+	Result *ExtractionResult
+	Err    error
+}
+
+// batchWriter is the output writer for a multi-file upload. An annotated
+// copy needs a single source workbook, so the SQL script is used instead.
+func (h *Handler) batchWriter(template string) OutputWriter {
+	writer, found := h.outputWriterFor(template)
+	if !found || writer.Name() == FormatAnnotated {
+		return SQLWriter{}
+	}
+	return writer
+}
+
+// readBatchEntries reads every entry with the same rule. Entries that are
+// not workbooks, or are encrypted, are only reported: there is no way to ask
+// for the password of one file among many.
+func (h *Handler) readBatchEntries(entries []batchEntry, rule Rule) {
+	for i := range entries {
+		entry := &entries[i]
+		if err := verifySpreadsheetSignature(entry.Path, entry.Name); err != nil {
+			entry.Err = err
+			continue
+		}
+		if encrypted, _ := isEncryptedWorkbook(entry.Path); encrypted {
+			entry.Err = ErrWorkbookEncrypted
+			continue
+		}
+		entry.Result, entry.Err = h.readWorkbook(entry.Path, rule, "")
+		if entry.Err != nil {
+			log.Printf("Error reading %s: %v", entry.Name, entry.Err)
+		}
+	}
+}
+
+// combineBatchResults merges the entries' results into one. Sheet names are
+// prefixed with the file name so every match keeps its provenance. Unless
+// the rule sets a duplicates option, a number found again in a later file or
+// row is dropped; the combined matches are then ordered as the rule asks.
+func combineBatchResults(sourcePath string, rule Rule, entries []batchEntry) *ExtractionResult {
+	combined := newExtractionResult(sourcePath, rule)
+
+	for _, entry := range entries {
+		if entry.Result == nil {
+			continue
+		}
+
+		for _, match := range entry.Result.Matches {
+			match.Sheet = entry.Name + " / " + match.Sheet
+			combined.Matches = append(combined.Matches, match)
+		}
+		for _, skipped := range entry.Result.Skipped {
+			skipped.Sheet = entry.Name + " / " + skipped.Sheet
+			combined.Skipped = append(combined.Skipped, skipped)
+		}
+		for _, sheet := range entry.Result.Sheets {
+			sheet.Name = entry.Name + " / " + sheet.Name
+			combined.Sheets = append(combined.Sheets, sheet)
+		}
+		for _, warning := range entry.Result.Warnings {
+			combined.Warnings = append(combined.Warnings, entry.Name+": "+warning)
+		}
+		combined.DuplicatesRemoved += entry.Result.DuplicatesRemoved
+	}
+
+	duplicates := rule.Duplicates
+	if duplicates == "" {
+		duplicates = DuplicatesFirst
+	}
+	combined.arrange(duplicates, rule.Order)
+
+	return combined
+}
+
+// formatBatchSummary lists the contracts found per file and the files that
+// could not be read, followed by the usual summary of the combined result.
+func formatBatchSummary(header string, entries []batchEntry, result *ExtractionResult) string {
+	text := header
+
+	for _, entry := range entries {
+		if entry.Err != nil {
+			text += fmt.Sprintf(TextBatchFileFailed, entry.Name, batchEntryErrorText(entry.Err))
+			continue
+		}
+		text += fmt.Sprintf(TextBatchFileLine, entry.Name, len(entry.Result.Matches))
+	}
+
+	return text + "\n" + formatExtractionSummary(result)
+}
+
+func batchEntryErrorText(err error) string {
+	switch {
+	case errors.Is(err, ErrWorkbookEncrypted):
+		return TextBatchReasonEncrypted
+	case errors.Is(err, ErrUnsupportedFormat):
+		return TextBatchReasonBinary
+	case errors.Is(err, ErrInvalidSignature):
+		return TextBatchReasonInvalid
+	default:
+		return TextBatchReasonUnreadable
+	}
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCombineBatchResults(t *testing.T) {
+	h := newTestArchiveHandler(t)
+	rule := h.defaultRule()
+
+	first := newExtractionResult("a.xlsx", rule)
+	first.beginSheet("Sheet1")
+	first.scanRow(0, []string{DefaultTargetText, "228960453", "123"})
+
+	second := newExtractionResult("c.xlsx", rule)
+	second.beginSheet("Sheet1")
+	second.scanRow(0, []string{DefaultTargetText, "228960453", "123"})
+	second.scanRow(1, []string{DefaultTargetText, "228960454", "456"})
+
+	entries := []batchEntry{
+		{Name: "a.xlsx", Result: first},
+		{Name: "b.xlsx", Err: ErrWorkbookEncrypted},
+		{Name: "c.xlsx", Result: second},
+	}
+
+	combined := combineBatchResults("registers.zip", rule, entries)
+	if len(combined.Matches) != 2 || combined.Matches[0].Sheet != "a.xlsx / Sheet1" || combined.Matches[1].Sheet != "c.xlsx / Sheet1" {
+		t.Fatalf("unexpected matches: %+v", combined.Matches)
+	}
+	if combined.DuplicatesRemoved != 1 {
+		t.Errorf("DuplicatesRemoved = %d, want 1", combined.DuplicatesRemoved)
+	}
+
+	summary := formatBatchSummary(TextArchiveHeader, entries, combined)
+	if !strings.Contains(summary, "a.xlsx: 1 contracts") || !strings.Contains(summary, "b.xlsx: ❌ "+TextBatchReasonEncrypted) {
+		t.Errorf("summary is missing per-file lines:\n%s", summary)
+	}
+	if !strings.Contains(summary, "Duplicates removed: 1") {
+		t.Errorf("summary is missing the duplicates line:\n%s", summary)
+	}
+
+	rule.Duplicates = DuplicatesKeep
+	if kept := combineBatchResults("registers.zip", rule, entries); len(kept.Matches) != 3 {
+		t.Errorf("keep: got %d matches, want 3", len(kept.Matches))
+	}
+}
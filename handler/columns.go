@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Types of the additional columns a rule captures. The type decides how a
+// value is normalised when it is read and how it is written to the script.
+const (
+	ColumnTypeText   = "text"
+	ColumnTypeNumber = "number"
+	ColumnTypeDate   = "date"
+)
+
+// reservedColumnNames are used by the VALUES list itself.
+var reservedColumnNames = []string{"number", "sort_seq", "target"}
+
+var columnName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// dateLayouts are the date formats found in registers; Excel's default
+// short date is rendered as mm-dd-yy.
+var dateLayouts = []string{
+	"2006-01-02",
+	"02.01.2006",
+	"2.1.2006",
+	"02.01.06",
+	"01-02-06",
+	"01/02/2006",
+	"1/2/06",
+	"2006-01-02 15:04:05",
+}
+
+// RuleColumn captures one more cell of a matched row into a named field,
+// such as the premium, start date or client name. Column is an absolute
+// column letter ("F"), Offset the distance from the matched cell; exactly
+// one of them is set.
+type RuleColumn struct {
+	Name   string `json:"name"`
+	Column string `json:"column,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Type   string `json:"type,omitempty"`
+
+	// index is the zero-based column of Column, or -1 for an offset.
+	index int
+}
+
+func (c *RuleColumn) compile() error {
+	c.Name = strings.ToLower(strings.TrimSpace(c.Name))
+	if !columnName.MatchString(c.Name) {
+		return fmt.Errorf("column name %q must be a lower-case identifier", c.Name)
+	}
+	for _, reserved := range reservedColumnNames {
+		if c.Name == reserved {
+			return fmt.Errorf("column name %q is reserved", c.Name)
+		}
+	}
+
+	switch {
+	case c.Column != "" && c.Offset != 0:
+		return fmt.Errorf("column %s: column and offset cannot be combined", c.Name)
+	case c.Column != "":
+		number, err := excelize.ColumnNameToNumber(c.Column)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		c.index = number - 1
+	case c.Offset != 0:
+		c.index = -1
+	default:
+		return fmt.Errorf("column %s: column or offset is required", c.Name)
+	}
+
+	c.Type = strings.ToLower(c.Type)
+	switch c.Type {
+	case "":
+		c.Type = ColumnTypeText
+	case ColumnTypeText, ColumnTypeNumber, ColumnTypeDate:
+	default:
+		return fmt.Errorf("column %s: unknown type %q", c.Name, c.Type)
+	}
+
+	return nil
+}
+
+// cellIndex is the zero-based column read for a match in matchCol.
+func (c RuleColumn) cellIndex(matchCol int) int {
+	if c.index >= 0 {
+		return c.index
+	}
+	return matchCol + c.Offset
+}
+
+// normalize converts a cell value to the column's type: numbers without
+// grouping and with a decimal point, dates as YYYY-MM-DD. It reports false
+// for values that cannot be converted.
+func (c RuleColumn) normalize(raw string) (string, bool) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return "", true
+	}
+
+	switch c.Type {
+	case ColumnTypeNumber:
+		return normalizeNumber(value)
+	case ColumnTypeDate:
+		return normalizeDate(value)
+	}
+	return value, true
+}
+
+// ambiguousComma matches numbers such as "1,234": 1.234 where the comma is
+// the decimal separator, 1234 where it groups thousands. Rather than guess,
+// such values are rejected.
+var ambiguousComma = regexp.MustCompile(`^[-+]?[1-9][0-9]{0,2},[0-9]{3}$`)
+
+func normalizeNumber(value string) (string, bool) {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '\'' {
+			return -1
+		}
+		return r
+	}, value)
+
+	if ambiguousComma.MatchString(value) {
+		return "", false
+	}
+
+	// The separator that comes last is the decimal one; the other groups
+	// thousands, e.g. "1.234,50" and "1,234.50".
+	comma, dot := strings.LastIndex(value, ","), strings.LastIndex(value, ".")
+	switch {
+	case comma > dot:
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.Replace(value, ",", ".", 1)
+	case dot > comma:
+		value = strings.ReplaceAll(value, ",", "")
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return "", false
+	}
+	return strconv.FormatFloat(number, 'f', -1, 64), true
+}
+
+func normalizeDate(value string) (string, bool) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.Format(time.DateOnly), true
+		}
+	}
+
+	// Raw values hold the Excel serial number.
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		if date, err := excelize.ExcelDateToTime(serial, false); err == nil {
+			return date.Format(time.DateOnly), true
+		}
+	}
+	return "", false
+}
+
+// captureColumns reads the rule's additional columns for a match in
+// matchCol. Values that do not fit their type are left empty and reported.
+func (r *ExtractionResult) captureColumns(match *ContractMatch, cells []string, matchCol int) {
+	if len(r.Rule.Columns) == 0 {
+		return
+	}
+
+	match.ColumnValues = make([]string, len(r.Rule.Columns))
+	for i, column := range r.Rule.Columns {
+		index := column.cellIndex(matchCol)
+		if index < 0 || index >= len(cells) {
+			continue
+		}
+
+		value, ok := column.normalize(cells[index])
+		if !ok {
+			cellName, _ := excelize.CoordinatesToCellName(index+1, match.Row)
+			r.addWarning("%s!%s: %q is not a valid %s for %s", match.Sheet, cellName, cells[index], column.Type, column.Name)
+			continue
+		}
+		match.ColumnValues[i] = value
+	}
+}
+
+// Column returns the value captured for the named column.
+func (m ContractMatch) Column(name string) string {
+	for i, column := range m.composer().Columns {
+		if column.Name == name && i < len(m.ColumnValues) {
+			return m.ColumnValues[i]
+		}
+	}
+	return ""
+}
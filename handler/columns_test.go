@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newColumnsRule(t *testing.T) Rule {
+	t.Helper()
+
+	rule := builtinRule("")
+	rule.Columns = []RuleColumn{
+		{Name: "premium", Offset: 3, Type: "number"},
+		{Name: "start_date", Column: "F", Type: "date"},
+		{Name: "client", Column: "G"},
+	}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	return rule
+}
+
+func TestRuleColumnCompile(t *testing.T) {
+	invalid := map[string]RuleColumn{
+		"no position":    {Name: "premium"},
+		"both positions": {Name: "premium", Column: "F", Offset: 2},
+		"bad name":       {Name: "client name", Column: "F"},
+		"reserved name":  {Name: "sort_seq", Column: "F"},
+		"bad column":     {Name: "premium", Column: "F1"},
+		"unknown type":   {Name: "premium", Column: "F", Type: "money"},
+	}
+	for name, column := range invalid {
+		if err := column.compile(); err == nil {
+			t.Errorf("%s: compile should fail", name)
+		}
+	}
+
+	rule := builtinRule("")
+	rule.Columns = []RuleColumn{{Name: "a", Column: "F"}, {Name: "A", Column: "G"}}
+	if err := rule.compile(); err == nil {
+		t.Error("duplicate column names should be rejected")
+	}
+}
+
+func TestRuleColumnNormalize(t *testing.T) {
+	tests := []struct {
+		columnType, raw, want string
+		ok                    bool
+	}{
+		{ColumnTypeNumber, "1 234,50", "1234.5", true},
+		{ColumnTypeNumber, "1,234.50", "1234.5", true},
+		{ColumnTypeNumber, "1.234,5", "1234.5", true},
+		{ColumnTypeNumber, "950", "950", true},
+		{ColumnTypeNumber, "n/a", "", false},
+		{ColumnTypeNumber, "1,234", "", false},
+		{ColumnTypeNumber, "-12,345", "", false},
+		{ColumnTypeNumber, "0,125", "0.125", true},
+		{ColumnTypeNumber, "12,5", "12.5", true},
+		{ColumnTypeNumber, "1234,567", "1234.567", true},
+		{ColumnTypeNumber, "NaN", "", false},
+		{ColumnTypeNumber, "Inf", "", false},
+		{ColumnTypeNumber, "-Infinity", "", false},
+		{ColumnTypeNumber, "1e400", "", false},
+		{ColumnTypeDate, "31.01.2024", "2024-01-31", true},
+		{ColumnTypeDate, "2024-01-31", "2024-01-31", true},
+		{ColumnTypeDate, "01-31-24", "2024-01-31", true},
+		{ColumnTypeDate, "45322", "2024-01-31", true},
+		{ColumnTypeDate, "soon", "", false},
+		{ColumnTypeText, " Іваненко ", "Іваненко", true},
+		{ColumnTypeNumber, "", "", true},
+	}
+
+	for _, tt := range tests {
+		column := RuleColumn{Name: "value", Column: "A", Type: tt.columnType}
+		got, ok := column.normalize(tt.raw)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalize(%s, %q) = %q, %v; want %q, %v", tt.columnType, tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCaptureColumns(t *testing.T) {
+	result := newExtractionResult("test.xlsx", newColumnsRule(t))
+	result.beginSheet("Sheet1")
+	result.scanRow(0, []string{"", DefaultTargetText, "228960453", "123", "1 500,00", "01.02.2024", "O'Brien"})
+	result.scanRow(1, []string{"", DefaultTargetText, "228960454", "456", "n/a"})
+
+	if len(result.Matches) != 2 {
+		t.Fatalf("got %d matches, want 2", len(result.Matches))
+	}
+	first, second := result.Matches[0], result.Matches[1]
+	if first.Column("premium") != "1500" || first.Column("start_date") != "2024-02-01" || first.Column("client") != "O'Brien" {
+		t.Errorf("unexpected values: %v", first.ColumnValues)
+	}
+	if second.Column("premium") != "" || second.Column("client") != "" {
+		t.Errorf("missing and invalid values should stay empty: %v", second.ColumnValues)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "Sheet1!E2") {
+		t.Errorf("expected one warning for the invalid premium, got %v", result.Warnings)
+	}
+
+	var sql bytes.Buffer
+	if err := (SQLWriter{}).Write(&sql, result); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	script := sql.String()
+	for _, want := range []string{
+		"sort_order.[premium] AS 'premium'",
+		"('EP-228960453-123', 0, 1500, CAST('2024-02-01' AS date), N'O''Brien')",
+		"('EP-228960454-456', 1, NULL, NULL, NULL)",
+		"AS sort_order(number, sort_seq, [premium], [start_date], [client])",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script is missing %q:\n%s", want, script)
+		}
+	}
+
+	var csv bytes.Buffer
+	if err := (CSVWriter{}).Write(&csv, result); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !strings.Contains(csv.String(), "number,sheet,row,cell,premium,start_date,client") {
+		t.Errorf("CSV header is missing the columns:\n%s", csv.String())
+	}
+
+	var output bytes.Buffer
+	if err := (JSONWriter{}).Write(&output, result); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	var decoded jsonOutput
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded.Contracts[0].Columns["start_date"] != "2024-02-01" {
+		t.Errorf("unexpected JSON columns: %v", decoded.Contracts[0].Columns)
+	}
+}
+
+func TestCaptureColumns_ReservedWords(t *testing.T) {
+	rule := builtinRule("")
+	rule.Columns = []RuleColumn{{Name: "order", Offset: 3, Type: "number"}, {Name: "user", Offset: 4}}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	result := newExtractionResult("test.xlsx", rule)
+	result.beginSheet("Sheet1")
+	result.scanRow(0, []string{"", DefaultTargetText, "228960453", "123", "7", "Ivanenko"})
+
+	for _, style := range []string{SQLStyleInline, SQLStyleTempTable} {
+		var sql bytes.Buffer
+		if err := (SQLWriter{Style: style}).Write(&sql, result); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		script := sql.String()
+		if !strings.Contains(script, "sort_order.[order] AS 'order'") || !strings.Contains(script, "sort_order.[user] AS 'user'") {
+			t.Errorf("%s: reserved column names should be bracketed:\n%s", style, script)
+		}
+		if strings.Contains(script, " order,") || strings.Contains(script, " user)") {
+			t.Errorf("%s: script has a bare reserved column name:\n%s", style, script)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultFilesDir        = "files"
+	DefaultDownloadTimeout = 60 * time.Second
+	DefaultMaxFileSizeMB   = 20
+	DefaultHistoryFile     = "history.json"
+	DefaultPreferencesFile = "preferences.json"
+	DefaultRulesFile       = "rules.json"
+	DefaultHistoryLimit    = 10
+	DefaultQueryTimeout    = 30 * time.Second
+	DefaultQueryMaxRows    = 10000
+
+	DefaultArchiveMaxEntries = 50
+	DefaultArchiveMaxSizeMB  = 200
+	DefaultArchiveMaxRatio   = 100
+
+	DefaultMediaGroupWindow = 3 * time.Second
+
+	DefaultTargetText     = "ББС ІНШУРАНС"
+	DefaultTemplateName   = FormatSQL
+	DefaultContractPrefix = "EP-"
+	DiffTemplateName      = "diff"
+)
+
+type Config struct {
+	FilesDir        string
+	DownloadTimeout time.Duration
+	MaxFileSize     int64
+	HistoryPath     string
+	HistoryLimit    int
+	PreferencesPath string
+
+	// DBDriver and DBDSN enable the "results" output format, which runs the
+	// generated query. The driver must be registered by the main package.
+	DBDriver     string
+	DBDSN        string
+	QueryTimeout time.Duration
+	QueryMaxRows int
+
+	// RulesPath points to the JSON list of extraction rules. Without it the
+	// built-in rule is used, validated with ContractPattern.
+	RulesPath       string
+	ContractPattern string
+
+	// Limits for .zip uploads: the number of spreadsheets, their total
+	// uncompressed size and the compression ratio of any single entry.
+	ArchiveMaxEntries int
+	ArchiveMaxSize    int64
+	ArchiveMaxRatio   int
+
+	// MediaGroupWindow is how long to wait for further files of a media
+	// group before the group is processed.
+	MediaGroupWindow time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		FilesDir:        DefaultFilesDir,
+		DownloadTimeout: DefaultDownloadTimeout,
+		MaxFileSize:     DefaultMaxFileSizeMB * 1024 * 1024,
+		HistoryPath:     filepath.Join(DefaultFilesDir, DefaultHistoryFile),
+		HistoryLimit:    DefaultHistoryLimit,
+		PreferencesPath: filepath.Join(DefaultFilesDir, DefaultPreferencesFile),
+		QueryTimeout:    DefaultQueryTimeout,
+		QueryMaxRows:    DefaultQueryMaxRows,
+		RulesPath:       filepath.Join(DefaultFilesDir, DefaultRulesFile),
+		ContractPattern: DefaultContractPattern,
+
+		ArchiveMaxEntries: DefaultArchiveMaxEntries,
+		ArchiveMaxSize:    DefaultArchiveMaxSizeMB * 1024 * 1024,
+		ArchiveMaxRatio:   DefaultArchiveMaxRatio,
+
+		MediaGroupWindow: DefaultMediaGroupWindow,
+	}
+}
+
+// LoadConfig reads handler settings from the environment, falling back to
+// DefaultConfig for anything unset or malformed.
+func LoadConfig() Config {
+	cfg := DefaultConfig()
+
+	if dir := os.Getenv("FILES_DIR"); dir != "" {
+		cfg.FilesDir = dir
+		cfg.HistoryPath = filepath.Join(dir, DefaultHistoryFile)
+		cfg.PreferencesPath = filepath.Join(dir, DefaultPreferencesFile)
+		cfg.RulesPath = filepath.Join(dir, DefaultRulesFile)
+	}
+	if path := os.Getenv("RULES_PATH"); path != "" {
+		cfg.RulesPath = path
+	}
+	if path := os.Getenv("HISTORY_PATH"); path != "" {
+		cfg.HistoryPath = path
+	}
+	if path := os.Getenv("PREFERENCES_PATH"); path != "" {
+		cfg.PreferencesPath = path
+	}
+	if seconds := getEnvInt("DOWNLOAD_TIMEOUT_SECONDS"); seconds > 0 {
+		cfg.DownloadTimeout = time.Duration(seconds) * time.Second
+	}
+	if sizeMB := getEnvInt("MAX_FILE_SIZE_MB"); sizeMB > 0 {
+		cfg.MaxFileSize = int64(sizeMB) * 1024 * 1024
+	}
+	cfg.DBDriver = os.Getenv("DB_DRIVER")
+	cfg.DBDSN = os.Getenv("DB_DSN")
+	if seconds := getEnvInt("DB_QUERY_TIMEOUT_SECONDS"); seconds > 0 {
+		cfg.QueryTimeout = time.Duration(seconds) * time.Second
+	}
+	if maxRows := getEnvInt("DB_MAX_ROWS"); maxRows > 0 {
+		cfg.QueryMaxRows = maxRows
+	}
+	if pattern := os.Getenv("CONTRACT_PATTERN"); pattern != "" {
+		cfg.ContractPattern = pattern
+	}
+	if entries := getEnvInt("ARCHIVE_MAX_ENTRIES"); entries > 0 {
+		cfg.ArchiveMaxEntries = entries
+	}
+	if sizeMB := getEnvInt("ARCHIVE_MAX_SIZE_MB"); sizeMB > 0 {
+		cfg.ArchiveMaxSize = int64(sizeMB) * 1024 * 1024
+	}
+	if ratio := getEnvInt("ARCHIVE_MAX_RATIO"); ratio > 0 {
+		cfg.ArchiveMaxRatio = ratio
+	}
+	if millis := getEnvInt("MEDIA_GROUP_WINDOW_MS"); millis > 0 {
+		cfg.MediaGroupWindow = time.Duration(millis) * time.Millisecond
+	}
+
+	return cfg
+}
+
+func getEnvInt(name string) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return 0
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid value for %s: %q", name, raw)
+		return 0
+	}
+
+	return value
+}
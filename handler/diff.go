@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const diffPreviewLimit = 20
+
+type ContractDiff struct {
+	Added     []string
+	Removed   []string
+	Unchanged []string
+}
+
+type diffBase struct {
+	record    HistoryRecord
+	contracts []string
+}
+
+// diffContracts compares two contract lists. Added and Unchanged keep the
+// order of the new list, Removed keeps the order of the old one, and every
+// contract is reported once even if it repeats within a register.
+func diffContracts(oldContracts, newContracts []string) ContractDiff {
+	oldSet := make(map[string]bool, len(oldContracts))
+	for _, contract := range oldContracts {
+		oldSet[contract] = true
+	}
+
+	newSet := make(map[string]bool, len(newContracts))
+	var diff ContractDiff
+
+	for _, contract := range newContracts {
+		if newSet[contract] {
+			continue
+		}
+		newSet[contract] = true
+
+		if oldSet[contract] {
+			diff.Unchanged = append(diff.Unchanged, contract)
+		} else {
+			diff.Added = append(diff.Added, contract)
+		}
+	}
+
+	seenRemoved := make(map[string]bool)
+	for _, contract := range oldContracts {
+		if newSet[contract] || seenRemoved[contract] {
+			continue
+		}
+		seenRemoved[contract] = true
+		diff.Removed = append(diff.Removed, contract)
+	}
+
+	return diff
+}
+
+func (h *Handler) handleDiffCommand(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatID := update.Message.Chat.ID
+	delete(h.diffBases, chatID)
+
+	if strings.TrimSpace(update.Message.CommandArguments()) == "" {
+		h.setState(chatID, StateAwaitingDiffBase)
+		msg := tgbotapi.NewMessage(chatID, TextDiffSendBase)
+		bot.Send(msg)
+		return
+	}
+
+	record, ok := h.lookupUserRecord(update, bot, TextDiffUsage)
+	if !ok {
+		return
+	}
+
+	result, err := h.readExcelFile(record.FilePath, h.uploadRule(record))
+	if err != nil {
+		log.Printf("Error reading Excel file: %v", err)
+		msg := tgbotapi.NewMessage(chatID, readErrorText(err))
+		bot.Send(msg)
+		return
+	}
+
+	contracts := result.Numbers()
+	h.diffBases[chatID] = diffBase{record: record, contracts: contracts}
+	h.setState(chatID, StateAwaitingDiffTarget)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextDiffSendTarget, record.FileName, len(contracts)))
+	bot.Send(msg)
+}
+
+// handleDiffUpload consumes a file sent while a /diff session is active: the
+// first file becomes the base register, the second one is compared to it.
+func (h *Handler) handleDiffUpload(bot *tgbotapi.BotAPI, upload HistoryRecord) {
+	chatID := upload.ChatID
+	upload.Template = DiffTemplateName
+
+	result, err := h.readExcelFile(upload.FilePath, h.uploadRule(upload))
+	if err != nil {
+		log.Printf("Error reading Excel file: %v", err)
+		msg := tgbotapi.NewMessage(chatID, readErrorText(err))
+		bot.Send(msg)
+		return
+	}
+	contracts := result.Numbers()
+	upload.ResultCount = len(contracts)
+
+	base, hasBase := h.diffBases[chatID]
+	if !hasBase || h.getState(chatID) == StateAwaitingDiffBase {
+		h.recordHistory(upload)
+		h.diffBases[chatID] = diffBase{record: upload, contracts: contracts}
+		h.setState(chatID, StateAwaitingDiffTarget)
+
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextDiffSendTarget, upload.FileName, len(contracts)))
+		bot.Send(msg)
+		return
+	}
+
+	delete(h.diffBases, chatID)
+	h.setState(chatID, StateDefault)
+
+	diff := diffContracts(base.contracts, contracts)
+	log.Printf("Diff of %s against %s: %d added, %d removed, %d unchanged",
+		upload.FileName, base.record.FileName, len(diff.Added), len(diff.Removed), len(diff.Unchanged))
+
+	summary := formatDiffSummary(base.record.FileName, len(base.contracts), upload.FileName, len(contracts), diff)
+	msg := tgbotapi.NewMessage(chatID, summary)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+
+	report := formatDiffReport(diff)
+	if err := h.sendDocument(bot, chatID, "diff.txt", []byte(report), TextDiffReportCaption); err != nil {
+		log.Printf("Error sending diff report: %v", err)
+	}
+
+	if len(diff.Added) > 0 {
+		script := buildSQLScript(diff.Added)
+		if err := h.sendDocument(bot, chatID, "script.txt", []byte(script), TextDiffScriptCaption); err != nil {
+			log.Printf("Error sending file to user: %v", err)
+			return
+		}
+
+		outputPath, err := h.saveOutput(upload.FilePath, SQLWriter{}.FileName(), []byte(script))
+		if err != nil {
+			log.Printf("Error saving output for %s: %v", upload.FilePath, err)
+		}
+		upload.OutputPath = outputPath
+	}
+
+	h.recordHistory(upload)
+}
+
+func formatDiffSummary(baseName string, baseCount int, targetName string, targetCount int, diff ContractDiff) string {
+	text := TextDiffHeader
+	text += fmt.Sprintf(TextDiffBaseLine, baseName, baseCount)
+	text += fmt.Sprintf(TextDiffTargetLine, targetName, targetCount)
+	text += fmt.Sprintf(TextDiffCounts, len(diff.Added), len(diff.Removed), len(diff.Unchanged))
+	text += formatDiffPreview(TextDiffAddedHeader, diff.Added)
+	text += formatDiffPreview(TextDiffRemovedHeader, diff.Removed)
+
+	return text
+}
+
+func formatDiffPreview(header string, contracts []string) string {
+	if len(contracts) == 0 {
+		return ""
+	}
+
+	text := header
+	for i, contract := range contracts {
+		if i == diffPreviewLimit {
+			text += fmt.Sprintf(TextDiffMore, len(contracts)-diffPreviewLimit)
+			break
+		}
+		text += "• " + contract + "\n"
+	}
+
+	return text
+}
+
+func formatDiffReport(diff ContractDiff) string {
+	var report strings.Builder
+
+	sections := []struct {
+		title     string
+		contracts []string
+	}{
+		{"ADDED", diff.Added},
+		{"REMOVED", diff.Removed},
+		{"UNCHANGED", diff.Unchanged},
+	}
+
+	for i, section := range sections {
+		if i > 0 {
+			report.WriteString("\n")
+		}
+		report.WriteString(fmt.Sprintf("%s (%d)\n", section.title, len(section.contracts)))
+		for _, contract := range section.contracts {
+			report.WriteString(contract + "\n")
+		}
+	}
+
+	return report.String()
+}
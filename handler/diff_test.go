@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffContracts(t *testing.T) {
+	oldContracts := []string{"111-a", "222-b", "333-c", "222-b"}
+	newContracts := []string{"444-d", "222-b", "111-a", "444-d", "555-e"}
+
+	diff := diffContracts(oldContracts, newContracts)
+
+	if want := []string{"444-d", "555-e"}; !reflect.DeepEqual(diff.Added, want) {
+		t.Errorf("Added = %v, want %v", diff.Added, want)
+	}
+	if want := []string{"333-c"}; !reflect.DeepEqual(diff.Removed, want) {
+		t.Errorf("Removed = %v, want %v", diff.Removed, want)
+	}
+	if want := []string{"222-b", "111-a"}; !reflect.DeepEqual(diff.Unchanged, want) {
+		t.Errorf("Unchanged = %v, want %v", diff.Unchanged, want)
+	}
+}
+
+func TestFormatDiffSummary(t *testing.T) {
+	added := make([]string, diffPreviewLimit+5)
+	for i := range added {
+		added[i] = "new"
+	}
+
+	summary := formatDiffSummary("old.xlsx", 3, "new.xlsx", 30, ContractDiff{Added: added})
+
+	if !strings.Contains(summary, "old.xlsx (3 contracts)") || !strings.Contains(summary, "new.xlsx (30 contracts)") {
+		t.Error("Summary should name both registers with their counts")
+	}
+	if !strings.Contains(summary, "… and 5 more") {
+		t.Error("Summary should truncate long lists")
+	}
+	if strings.Contains(summary, "Removed contracts") {
+		t.Error("Summary should omit empty sections")
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrFileTooLarge     = errors.New("file exceeds maximum allowed size")
+	ErrInvalidSignature = errors.New("file content does not match a spreadsheet format")
+)
+
+var (
+	ole2Signature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+	zipSignature  = []byte{0x50, 0x4B, 0x03, 0x04}
+)
+
+type DownloadResult struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+func (h *Handler) downloadAndSaveFile(ctx context.Context, fileURL, fileName string) (*DownloadResult, error) {
+	filesDir := h.config.FilesDir
+	if err := os.MkdirAll(filesDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create files directory: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.config.DownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", withoutURL(err))
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	if resp.ContentLength > h.config.MaxFileSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFileTooLarge, resp.ContentLength)
+	}
+
+	tmpFile, err := os.CreateTemp(filesDir, ".download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	hasher := sha256.New()
+	limited := io.LimitReader(resp.Body, h.config.MaxFileSize+1)
+
+	written, err := io.Copy(io.MultiWriter(tmpFile, hasher), limited)
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", withoutURL(err))
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("failed to write file: %w", closeErr)
+	}
+
+	if written > h.config.MaxFileSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrFileTooLarge, h.config.MaxFileSize)
+	}
+
+	verify := verifySpreadsheetSignature
+	if isArchiveFile(fileName) {
+		verify = verifyArchiveSignature
+	}
+	if err := verify(tmpPath, fileName); err != nil {
+		return nil, err
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	// Prefixing with the checksum keeps earlier uploads with the same name
+	// available for /rerun instead of overwriting them.
+	filePath := filepath.Join(filesDir, checksum[:12]+"_"+filepath.Base(fileName))
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	return &DownloadResult{
+		Path:   filePath,
+		Size:   written,
+		SHA256: checksum,
+	}, nil
+}
+
+// withoutURL drops the request URL from an HTTP client error. Telegram file
+// URLs carry the bot token, and download errors end up in the log.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// verifySpreadsheetSignature checks the content so that renamed or truncated
+// uploads are rejected before they reach the readers: legacy .xls workbooks
+// are OLE2 compound files, the OOXML formats are ZIP packages. Binary .xlsb
+// workbooks are recognized and rejected as unsupported. The file name is
+// only used in the error.
+func verifySpreadsheetSignature(filePath, fileName string) error {
+	kind, err := sniffWorkbook(filePath)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case kindUnknown:
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, fileName)
+	case kindXLSB:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, fileName)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDownloadHandler(t *testing.T, maxFileSize int64) *Handler {
+	cfg := DefaultConfig()
+	cfg.FilesDir = t.TempDir()
+	cfg.MaxFileSize = maxFileSize
+	cfg.DownloadTimeout = 5 * time.Second
+	return NewHandlerWithConfig(cfg)
+}
+
+func serveBytes(t *testing.T, body []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadAndSaveFile(t *testing.T) {
+	t.Run("valid xlsx is saved with checksum", func(t *testing.T) {
+		h := newTestDownloadHandler(t, 1024)
+		body := append(append([]byte{}, zipSignature...), []byte("payload")...)
+		server := serveBytes(t, body)
+
+		result, err := h.downloadAndSaveFile(context.Background(), server.URL, "report.xlsx")
+		if err != nil {
+			t.Fatalf("downloadAndSaveFile failed: %v", err)
+		}
+
+		sum := sha256.Sum256(body)
+		if result.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("SHA256 = %s, want %s", result.SHA256, hex.EncodeToString(sum[:]))
+		}
+		if result.Size != int64(len(body)) {
+			t.Errorf("Size = %d, want %d", result.Size, len(body))
+		}
+
+		saved, err := os.ReadFile(result.Path)
+		if err != nil {
+			t.Fatalf("Failed to read saved file: %v", err)
+		}
+		if !bytes.Equal(saved, body) {
+			t.Error("Saved file content differs from downloaded content")
+		}
+	})
+
+	t.Run("valid xls requires OLE2 header", func(t *testing.T) {
+		h := newTestDownloadHandler(t, 1024)
+		server := serveBytes(t, append(append([]byte{}, ole2Signature...), 0x00))
+
+		if _, err := h.downloadAndSaveFile(context.Background(), server.URL, "legacy.xls"); err != nil {
+			t.Fatalf("downloadAndSaveFile failed: %v", err)
+		}
+	})
+
+	t.Run("zip archive requires ZIP header", func(t *testing.T) {
+		h := newTestDownloadHandler(t, 1024)
+		server := serveBytes(t, append(append([]byte{}, zipSignature...), []byte("payload")...))
+
+		if _, err := h.downloadAndSaveFile(context.Background(), server.URL, "registers.zip"); err != nil {
+			t.Fatalf("downloadAndSaveFile failed: %v", err)
+		}
+
+		server = serveBytes(t, append(append([]byte{}, ole2Signature...), 0x00))
+		if _, err := h.downloadAndSaveFile(context.Background(), server.URL, "legacy.zip"); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+		}
+	})
+
+	t.Run("renamed file is rejected", func(t *testing.T) {
+		h := newTestDownloadHandler(t, 1024)
+		server := serveBytes(t, []byte("plain text, not a workbook"))
+
+		_, err := h.downloadAndSaveFile(context.Background(), server.URL, "fake.xlsx")
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+		}
+
+		entries, _ := os.ReadDir(h.config.FilesDir)
+		if len(entries) != 0 {
+			t.Errorf("Rejected download should not leave files behind, found %d", len(entries))
+		}
+	})
+
+	t.Run("oversized file is rejected while streaming", func(t *testing.T) {
+		h := newTestDownloadHandler(t, 16)
+		body := append(append([]byte{}, zipSignature...), bytes.Repeat([]byte("x"), 64)...)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Flush before writing so no Content-Length is sent up front.
+			w.(http.Flusher).Flush()
+			w.Write(body)
+		}))
+		defer server.Close()
+
+		_, err := h.downloadAndSaveFile(context.Background(), server.URL, "big.xlsx")
+		if !errors.Is(err, ErrFileTooLarge) {
+			t.Errorf("Expected ErrFileTooLarge, got: %v", err)
+		}
+	})
+
+	t.Run("timeout is enforced", func(t *testing.T) {
+		h := newTestDownloadHandler(t, 1024)
+		h.config.DownloadTimeout = 50 * time.Millisecond
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+		}))
+		defer server.Close()
+
+		_, err := h.downloadAndSaveFile(context.Background(), server.URL, "slow.xlsx")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline exceeded, got: %v", err)
+		}
+	})
+
+	t.Run("errors do not contain the bot token", func(t *testing.T) {
+		h := newTestDownloadHandler(t, 1024)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		fileURL := server.URL + "/file/bot123456:SECRET-TOKEN/documents/file_1.xlsx"
+		server.Close()
+
+		_, err := h.downloadAndSaveFile(context.Background(), fileURL, "report.xlsx")
+		if err == nil {
+			t.Fatal("Expected an error for a closed server")
+		}
+		if strings.Contains(err.Error(), "SECRET-TOKEN") {
+			t.Errorf("Error should not contain the file URL: %v", err)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const outputsDirName = "outputs"
+
+// offerCachedOutput checks whether a file with the same content was already
+// processed. The user is always told about the earlier run; if its output
+// fits the current request and is still on disk, they are asked whether to
+// resend it or process the file again. It reports whether the offer was
+// made; the upload is then parked until the user answers.
+func (h *Handler) offerCachedOutput(bot *tgbotapi.BotAPI, upload HistoryRecord) bool {
+	earlier, found := h.earlierProcessing(upload)
+	if !found {
+		return false
+	}
+
+	previous, reusable := h.reusableOutput(upload)
+	if !reusable {
+		text := fmt.Sprintf(TextDuplicateProcessedBefore,
+			earlier.ProcessedAt.Format("2006-01-02 15:04"),
+			historyUserName(earlier),
+			earlier.FileName,
+			earlier.ResultCount,
+		)
+		msg := tgbotapi.NewMessage(upload.ChatID, text)
+		if _, err := bot.Send(msg); err != nil {
+			log.Printf("Error sending message: %v", err)
+		}
+		return false
+	}
+
+	upload.ReusedFrom = previous.ID
+	h.pendingDuplicates[upload.ChatID] = upload
+
+	text := fmt.Sprintf(TextDuplicateDetected,
+		previous.ProcessedAt.Format("2006-01-02 15:04"),
+		historyUserName(previous),
+		previous.FileName,
+		previous.ResultCount,
+	)
+
+	msg := tgbotapi.NewMessage(upload.ChatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(TextDuplicateResendButton, CallbackDuplicateResend),
+			tgbotapi.NewInlineKeyboardButtonData(TextDuplicateProcessButton, CallbackDuplicateProcess),
+		),
+	)
+
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
+		delete(h.pendingDuplicates, upload.ChatID)
+		return false
+	}
+
+	log.Printf("Duplicate upload %s in chat %d matches history entry #%d", upload.FileName, upload.ChatID, previous.ID)
+	return true
+}
+
+// earlierProcessing returns the latest run of a file with the same content,
+// whatever its settings.
+func (h *Handler) earlierProcessing(upload HistoryRecord) (HistoryRecord, bool) {
+	if h.history == nil {
+		return HistoryRecord{}, false
+	}
+
+	visible := visibleRuns(upload)
+	return h.history.FindLatest(func(record HistoryRecord) bool {
+		return record.SHA256 == upload.SHA256 && visible(record)
+	})
+}
+
+// visibleRuns limits the history an upload is matched against. The output
+// of an encrypted workbook is its decrypted content, so runs of one are
+// only matched for the user who opened it; a file that cannot be checked
+// is treated the same way.
+func visibleRuns(upload HistoryRecord) func(HistoryRecord) bool {
+	encrypted, err := isEncryptedWorkbook(upload.FilePath)
+	if err != nil {
+		log.Printf("Error checking %s for encryption: %v", upload.FilePath, err)
+	}
+	if !encrypted && err == nil {
+		return func(HistoryRecord) bool { return true }
+	}
+	return func(record HistoryRecord) bool { return record.UserID == upload.UserID }
+}
+
+// reusableOutput returns the latest output that can stand in for processing
+// the upload: in the requested format and of the same rule and options. This
+// also excludes diff runs, which store a script for added contracts. Records
+// from before rules existed resolve to the default rule. Query results
+// depend on the current database state, so they are never reused.
+func (h *Handler) reusableOutput(upload HistoryRecord) (HistoryRecord, bool) {
+	if h.history == nil || upload.Template == FormatResults {
+		return HistoryRecord{}, false
+	}
+
+	visible := visibleRuns(upload)
+	previous, found := h.history.FindLatest(func(record HistoryRecord) bool {
+		return record.SHA256 == upload.SHA256 && visible(record) && record.OutputPath != "" && record.Template == upload.Template &&
+			h.resolveRule("", record.Rule).Name == upload.Rule && slices.Equal(record.Sheets, upload.Sheets) &&
+			slices.Equal(record.Targets, upload.Targets) &&
+			record.Duplicates == upload.Duplicates && record.Order == upload.Order
+	})
+	if !found {
+		return HistoryRecord{}, false
+	}
+
+	if _, err := os.Stat(previous.OutputPath); err != nil {
+		log.Printf("Cached output for history entry #%d is unavailable: %v", previous.ID, err)
+		return HistoryRecord{}, false
+	}
+
+	return previous, true
+}
+
+func (h *Handler) handleDuplicateCallback(query *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	chatID := query.Message.Chat.ID
+
+	upload, exists := h.pendingDuplicates[chatID]
+	if !exists {
+		msg := tgbotapi.NewMessage(chatID, TextDuplicateExpired)
+		bot.Send(msg)
+		return
+	}
+	delete(h.pendingDuplicates, chatID)
+
+	if query.Data == CallbackDuplicateProcess {
+		upload.ReusedFrom = 0
+		h.processUpload(bot, upload)
+		return
+	}
+
+	previous, found := h.history.Get(upload.ReusedFrom)
+	if !found {
+		h.processUpload(bot, upload)
+		return
+	}
+
+	if err := h.sendCachedOutput(bot, chatID, previous); err != nil {
+		log.Printf("Error resending cached output of entry #%d: %v", previous.ID, err)
+		h.processUpload(bot, upload)
+		return
+	}
+
+	upload.ResultCount = previous.ResultCount
+	upload.OutputPath = previous.OutputPath
+	upload.Rule = previous.Rule
+	upload.Template = previous.Template
+	h.recordHistory(upload)
+}
+
+func (h *Handler) sendCachedOutput(bot *tgbotapi.BotAPI, chatID int64, record HistoryRecord) error {
+	writer, found := h.outputWriterFor(record.Template)
+	if !found {
+		return fmt.Errorf("unknown output format: %s", record.Template)
+	}
+
+	content, err := os.ReadFile(record.OutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read cached output: %w", err)
+	}
+
+	return h.sendDocument(bot, chatID, writer.FileName(), content, TextCachedOutputSent)
+}
+
+// saveOutput keeps a copy of the generated output next to the stored upload so
+// it can be resent for duplicate uploads without reprocessing.
+func (h *Handler) saveOutput(filePath, outputFileName string, content []byte) (string, error) {
+	outputsDir := filepath.Join(h.config.FilesDir, outputsDirName)
+	if err := os.MkdirAll(outputsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create outputs directory: %w", err)
+	}
+
+	baseName := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	outputName := fmt.Sprintf("%s_%s_%s", baseName, time.Now().Format("20060102-150405.000"), outputFileName)
+	outputPath := filepath.Join(outputsDir, outputName)
+
+	if err := os.WriteFile(outputPath, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write output: %w", err)
+	}
+
+	return outputPath, nil
+}
+
+func historyUserName(record HistoryRecord) string {
+	if record.Username != "" {
+		return "@" + record.Username
+	}
+	return fmt.Sprintf("user %d", record.UserID)
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestHistoryHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.FilesDir = t.TempDir()
+	cfg.HistoryPath = filepath.Join(cfg.FilesDir, "history.json")
+	cfg.PreferencesPath = filepath.Join(cfg.FilesDir, "preferences.json")
+	cfg.RulesPath = ""
+	return NewHandlerWithConfig(cfg)
+}
+
+func TestReusableOutput(t *testing.T) {
+	h := newTestHistoryHandler(t)
+
+	outputPath := filepath.Join(h.config.FilesDir, "script.txt")
+	if err := os.WriteFile(outputPath, []byte("SELECT 1"), 0644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	if _, err := h.history.Add(HistoryRecord{
+		UserID: 1, FileName: "a.xlsx", SHA256: "abc", OutputPath: outputPath,
+		Rule: DefaultRuleName, Template: FormatSQL,
+	}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	uploadPath := filepath.Join(h.config.FilesDir, "a.xlsx")
+	if err := os.WriteFile(uploadPath, []byte("PK"), 0644); err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+
+	upload := HistoryRecord{UserID: 2, FilePath: uploadPath, SHA256: "abc", Rule: DefaultRuleName, Template: FormatSQL}
+	if _, ok := h.reusableOutput(upload); !ok {
+		t.Error("an output with the same settings should be reusable")
+	}
+
+	upload.Template = FormatCSV
+	if _, ok := h.reusableOutput(upload); ok {
+		t.Error("an output in another format should not be reusable")
+	}
+	if earlier, ok := h.earlierProcessing(upload); !ok || earlier.FileName != "a.xlsx" {
+		t.Error("the earlier run should still be reported when its output does not fit")
+	}
+
+	if _, ok := h.earlierProcessing(HistoryRecord{SHA256: "other"}); ok {
+		t.Error("a file with other content was not processed before")
+	}
+}
+
+func TestReusableOutput_EncryptedOnlyForSameUser(t *testing.T) {
+	h := newTestHistoryHandler(t)
+
+	outputPath := filepath.Join(h.config.FilesDir, "script.txt")
+	if err := os.WriteFile(outputPath, []byte("SELECT 1"), 0644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	if _, err := h.history.Add(HistoryRecord{
+		UserID: 1, FileName: "protected.xlsx", SHA256: "abc", OutputPath: outputPath,
+		Rule: DefaultRuleName, Template: FormatSQL,
+	}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	upload := HistoryRecord{
+		UserID: 2, FilePath: writeEncryptedWorkbook(t, "secret"), SHA256: "abc",
+		Rule: DefaultRuleName, Template: FormatSQL,
+	}
+	if _, ok := h.reusableOutput(upload); ok {
+		t.Error("another user's output of an encrypted workbook should not be reusable")
+	}
+	if _, ok := h.earlierProcessing(upload); ok {
+		t.Error("another user's run of an encrypted workbook should not be reported")
+	}
+
+	upload.UserID = 1
+	if _, ok := h.reusableOutput(upload); !ok {
+		t.Error("the same user's output of an encrypted workbook should be reusable")
+	}
+}
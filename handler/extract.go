@@ -0,0 +1,359 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/extrame/xls"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	SkipReasonMissingValues = "contract values missing after match"
+	SkipReasonInvalidNumber = "invalid contract number"
+)
+
+type ExtractionOutcome string
+
+const (
+	OutcomeMatched   ExtractionOutcome = "matched"
+	OutcomeNoMatches ExtractionOutcome = "no_matches"
+)
+
+// ContractMatch is a row where the target text was found, together with the
+// location of the matched cell and the values taken from its neighbours.
+type ContractMatch struct {
+	Sheet      string
+	Row        int
+	Cell       string
+	Values     []string
+	ValueCells []string
+	// Target is the rule target found in the matched cell.
+	Target string
+	// ColumnValues holds the rule's additional columns, in rule order and
+	// normalised to their type; empty when missing or invalid.
+	ColumnValues []string
+
+	// rule composes the number; nil means the default rule.
+	rule *Rule
+}
+
+func (m ContractMatch) composer() Rule {
+	if m.rule == nil {
+		return builtinRule("")
+	}
+	return *m.rule
+}
+
+func (m ContractMatch) Contract() string {
+	return m.composer().Compose(m.Values)
+}
+
+// Number is the full contract number as stored in the database.
+func (m ContractMatch) Number() string {
+	return m.composer().Prefix + m.Contract()
+}
+
+type SkippedRow struct {
+	Sheet  string
+	Row    int
+	Cell   string
+	Reason string
+}
+
+type SheetStats struct {
+	Name        string
+	RowsScanned int
+	Matches     int
+	Skipped     int
+}
+
+// ExtractionResult is what the readers produce. Rendering it into SQL or any
+// other format is left to an OutputWriter.
+type ExtractionResult struct {
+	SourcePath string
+	TargetText string
+	Matches    []ContractMatch
+	Skipped    []SkippedRow
+	Warnings   []string
+	Sheets     []SheetStats
+	Rule       Rule
+	// ExcludedSheets were skipped because of the rule's sheet patterns or
+	// the user's selection.
+	ExcludedSheets []string
+	// DuplicatesRemoved counts the matches dropped by the rule's duplicates
+	// option.
+	DuplicatesRemoved int
+
+	// scan is the region state of the sheet begun last.
+	scan sheetScan
+
+	// password reopens an encrypted source, e.g. for the annotated copy.
+	// It is deliberately unexported so no output format can include it.
+	password string
+}
+
+func newExtractionResult(sourcePath string, rule Rule) *ExtractionResult {
+	targetText := strings.Join(rule.targetList(), ", ")
+	return &ExtractionResult{SourcePath: sourcePath, TargetText: targetText, Rule: rule}
+}
+
+func (r *ExtractionResult) Outcome() ExtractionOutcome {
+	if len(r.Matches) == 0 {
+		return OutcomeNoMatches
+	}
+	return OutcomeMatched
+}
+
+func (r *ExtractionResult) Contracts() []string {
+	contracts := make([]string, 0, len(r.Matches))
+	for _, match := range r.Matches {
+		contracts = append(contracts, match.Contract())
+	}
+	return contracts
+}
+
+// Numbers returns the full contract numbers, prefix included.
+func (r *ExtractionResult) Numbers() []string {
+	numbers := make([]string, 0, len(r.Matches))
+	for _, match := range r.Matches {
+		numbers = append(numbers, match.Number())
+	}
+	return numbers
+}
+
+func (r *ExtractionResult) addWarning(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	r.Warnings = append(r.Warnings, warning)
+	log.Print(warning)
+}
+
+// beginSheet starts the statistics of the next sheet; scanRow always updates
+// the sheet begun last.
+func (r *ExtractionResult) beginSheet(sheetName string) {
+	r.Sheets = append(r.Sheets, SheetStats{Name: sheetName})
+	r.scan = sheetScan{region: r.Rule.region, stopAtEmpty: r.Rule.StopAtEmptyRow, headerRow: r.Rule.HeaderRow}
+}
+
+// scanRow looks for the target text in a row and records either a match or
+// the reason the row was skipped. The rule's fields are read from the cells
+// right after the match. rowIndex and the column indexes are zero-based;
+// cells holds the row's values starting from column 0. Rows outside the
+// rule's region are ignored.
+func (r *ExtractionResult) scanRow(rowIndex int, cells []string) {
+	stats := &r.Sheets[len(r.Sheets)-1]
+
+	wasEnded := r.scan.ended
+	cells, inRegion := r.scan.visible(rowIndex, cells)
+	if !inRegion {
+		if r.scan.ended && !wasEnded && stats.Matches == 0 && stats.Skipped == 0 {
+			r.addWarning("Sheet %s ended at empty row %d before any contract was found", stats.Name, r.scan.lastRow+1)
+		}
+		return
+	}
+
+	stats.RowsScanned++
+
+	for colIndex, cell := range cells {
+		target, found := r.Rule.matchTarget(cell)
+		if !found {
+			continue
+		}
+
+		cellName, _ := excelize.CoordinatesToCellName(colIndex+1, rowIndex+1)
+		r.scan.anchor()
+
+		fieldCount := len(r.Rule.Fields)
+		if colIndex+fieldCount >= len(cells) {
+			r.skipRow(stats, rowIndex, cellName, SkipReasonMissingValues)
+			return
+		}
+
+		match := ContractMatch{
+			Sheet:  stats.Name,
+			Row:    rowIndex + 1,
+			Cell:   cellName,
+			Target: target,
+			rule:   &r.Rule,
+		}
+		for offset := 1; offset <= fieldCount; offset++ {
+			valueCell, _ := excelize.CoordinatesToCellName(colIndex+offset+1, rowIndex+1)
+			match.Values = append(match.Values, normalizeContractValue(cells[colIndex+offset]))
+			match.ValueCells = append(match.ValueCells, valueCell)
+		}
+
+		if !r.Rule.validNumber(match.Contract()) {
+			r.skipRow(stats, rowIndex, cellName, fmt.Sprintf("%s %q", SkipReasonInvalidNumber, match.Contract()))
+			return
+		}
+
+		r.captureColumns(&match, cells, colIndex)
+
+		r.Matches = append(r.Matches, match)
+		stats.Matches++
+		log.Printf("Found match in sheet %s at %s: %s", stats.Name, cellName, match.Contract())
+		return
+	}
+}
+
+func (r *ExtractionResult) skipRow(stats *SheetStats, rowIndex int, cellName, reason string) {
+	r.Skipped = append(r.Skipped, SkippedRow{
+		Sheet:  stats.Name,
+		Row:    rowIndex + 1,
+		Cell:   cellName,
+		Reason: reason,
+	})
+	stats.Skipped++
+	log.Printf("Skipped match in sheet %s at %s: %s", stats.Name, cellName, reason)
+}
+
+func (h *Handler) readExcelFile(filePath string, rule Rule) (*ExtractionResult, error) {
+	return h.readWorkbook(filePath, rule, "")
+}
+
+// readWorkbook reads an upload with the password given by the user; it is
+// only used for encrypted .xlsx files. The reader is chosen by the file
+// content rather than its name, and the matches are arranged as the rule
+// asks.
+func (h *Handler) readWorkbook(filePath string, rule Rule, password string) (*ExtractionResult, error) {
+	var result *ExtractionResult
+	var err error
+
+	switch workbookKindOf(filePath) {
+	case kindXLS:
+		result, err = h.readXlsFile(filePath, rule)
+	case kindXLSB:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, filePath)
+	default:
+		result, err = h.readXlsxFile(filePath, rule, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.arrange(rule.Duplicates, rule.Order)
+	return result, nil
+}
+
+func (h *Handler) readXlsxFile(filePath string, rule Rule, password string) (*ExtractionResult, error) {
+	f, err := excelize.OpenFile(filePath, excelize.Options{Password: password})
+	if err != nil {
+		return nil, openWorkbookError(filePath, password, err)
+	}
+	defer f.Close()
+
+	result := newExtractionResult(filePath, rule)
+	result.password = password
+
+	var pkg *xlsxPackage
+	if rule.MergedCells || rule.EvaluateFormulas || rule.Table != "" {
+		pkg, err = openXlsxPackage(filePath, password)
+		if err != nil {
+			result.addWarning("Merged cells, formulas and tables are read as stored: %v", err)
+		} else {
+			defer pkg.Close()
+		}
+	}
+
+	var definedNames []excelize.DefinedName
+	if rule.Table != "" {
+		definedNames = f.GetDefinedName()
+	}
+
+	sheetList := f.GetSheetList()
+	for _, sheetName := range sheetList {
+		if !rule.includesSheet(sheetName) {
+			result.ExcludedSheets = append(result.ExcludedSheets, sheetName)
+			continue
+		}
+
+		var region cellRegion
+		if rule.Table != "" {
+			var tables map[string]string
+			if pkg != nil {
+				if tables, err = pkg.sheetTables(sheetName); err != nil {
+					result.addWarning("Tables of sheet %s could not be read: %v", sheetName, err)
+				}
+			}
+
+			var found bool
+			if region, found = namedRegion(definedNames, tables, sheetName, rule.Table); !found {
+				result.ExcludedSheets = append(result.ExcludedSheets, sheetName)
+				continue
+			}
+		}
+
+		result.beginSheet(sheetName)
+		if rule.Table != "" {
+			result.scan.region = &region
+		}
+
+		err := streamXlsxRows(f, pkg, sheetName, rule, result, result.scanRow)
+		if err != nil {
+			result.addWarning("Error reading sheet %s: %v", sheetName, err)
+		}
+	}
+
+	return result, nil
+}
+
+// openWorkbookError tells an encrypted workbook and a wrong password apart
+// from other failures. excelize reports a failed decryption as an
+// unsupported format, so encryption is checked on the file itself.
+func openWorkbookError(filePath, password string, err error) error {
+	if password != "" && (errors.Is(err, excelize.ErrWorkbookPassword) || errors.Is(err, excelize.ErrWorkbookFileFormat)) {
+		return ErrWrongPassword
+	}
+	if encrypted, _ := isEncryptedWorkbook(filePath); encrypted {
+		return ErrWorkbookEncrypted
+	}
+	return fmt.Errorf("failed to open Excel file: %w", err)
+}
+
+func (h *Handler) readXlsFile(filePath string, rule Rule) (*ExtractionResult, error) {
+	xlsFile, err := xls.Open(filePath, "utf-8")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLS file: %w", err)
+	}
+
+	cellReader, err := newXlsCellReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLS file: %w", err)
+	}
+
+	result := newExtractionResult(filePath, rule)
+
+	if rule.Table != "" {
+		result.addWarning("Defined names and tables can only be read from .xlsx workbooks, so table %s was ignored", rule.Table)
+	}
+
+	for sheetIndex := 0; sheetIndex < xlsFile.NumSheets(); sheetIndex++ {
+		sheet := xlsFile.GetSheet(sheetIndex)
+		if sheet == nil {
+			result.addWarning("Sheet %d could not be read", sheetIndex+1)
+			continue
+		}
+
+		if !rule.includesSheet(sheet.Name) {
+			result.ExcludedSheets = append(result.ExcludedSheets, sheet.Name)
+			continue
+		}
+
+		result.beginSheet(sheet.Name)
+		cells := cellReader.sheetCells(sheetIndex)
+
+		maxRow := int(sheet.MaxRow)
+		for rowIndex := 0; rowIndex <= maxRow; rowIndex++ {
+			row := sheet.Row(rowIndex)
+			if row == nil {
+				continue
+			}
+
+			result.scanRow(rowIndex, cells.rowValues(rowIndex, row))
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestExtractXlsx_Provenance(t *testing.T) {
+	h := NewHandler()
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetCellValue("Sheet1", "A1", "Header")
+	f.SetCellValue("Sheet1", "B2", "ББС ІНШУРАНС")
+	f.SetCellValue("Sheet1", "C2", "228960453")
+	f.SetCellValue("Sheet1", "D2", "123")
+	f.SetCellValue("Sheet1", "A3", "ББС ІНШУРАНС")
+	f.SetCellValue("Sheet1", "B3", "OnlyOne")
+
+	testFile := filepath.Join(t.TempDir(), "provenance.xlsx")
+	if err := f.SaveAs(testFile); err != nil {
+		t.Fatalf("Failed to save test file: %v", err)
+	}
+
+	result, err := h.readExcelFile(testFile, h.defaultRule())
+	if err != nil {
+		t.Fatalf("readExcelFile failed: %v", err)
+	}
+
+	if len(result.Matches) != 1 {
+		t.Fatalf("Expected 1 match, got %d", len(result.Matches))
+	}
+
+	match := result.Matches[0]
+	if match.Sheet != "Sheet1" || match.Row != 2 || match.Cell != "B2" {
+		t.Errorf("Unexpected provenance: %+v", match)
+	}
+	if match.Contract() != "228960453-123" {
+		t.Errorf("Contract() = %q, want %q", match.Contract(), "228960453-123")
+	}
+
+	if len(result.Skipped) != 1 {
+		t.Fatalf("Expected 1 skipped row, got %d", len(result.Skipped))
+	}
+	if skipped := result.Skipped[0]; skipped.Row != 3 || skipped.Reason != SkipReasonMissingValues {
+		t.Errorf("Unexpected skipped row: %+v", skipped)
+	}
+}
+
+func TestFormatExtractionSummary(t *testing.T) {
+	result := &ExtractionResult{
+		Matches: []ContractMatch{
+			{Sheet: "Jan", Row: 2, Cell: "A2", Values: []string{"111", "a"}},
+			{Sheet: "Feb", Row: 5, Cell: "C5", Values: []string{"222", "b"}},
+			{Sheet: "Jan", Row: 3, Cell: "A3", Values: []string{"333", "c"}},
+		},
+		Skipped: []SkippedRow{
+			{Sheet: "Feb", Row: 9, Cell: "A9", Reason: SkipReasonMissingValues},
+		},
+		Sheets: []SheetStats{
+			{Name: "Jan", RowsScanned: 10, Matches: 2},
+			{Name: "Feb", RowsScanned: 12, Matches: 1, Skipped: 1},
+			{Name: "Notes", RowsScanned: 3},
+		},
+		Warnings: []string{"Error reading sheet Archive"},
+	}
+
+	summary := formatExtractionSummary(result)
+
+	expected := []string{
+		"Matched rows: 3",
+		"• Jan: 2",
+		"• Feb: 1",
+		"• 222-b (Feb!C5)",
+		"Skipped rows: 1",
+		"• Feb, row 9: " + SkipReasonMissingValues,
+		"• Error reading sheet Archive",
+	}
+	for _, part := range expected {
+		if !strings.Contains(summary, part) {
+			t.Errorf("Summary should contain %q, got:\n%s", part, summary)
+		}
+	}
+
+	if strings.Index(summary, "• Jan: 2") > strings.Index(summary, "• Feb: 1") {
+		t.Error("Sheets should be listed in workbook order")
+	}
+	if strings.Contains(summary, "Notes") {
+		t.Error("Sheets without matches or skipped rows should be omitted")
+	}
+
+	t.Run("no matches", func(t *testing.T) {
+		summary := formatExtractionSummary(&ExtractionResult{TargetText: DefaultTargetText})
+		if !strings.Contains(summary, "No matching data found for '"+DefaultTargetText+"'") {
+			t.Errorf("No-match summary should name the target, got:\n%s", summary)
+		}
+	})
+}
+
+func TestFormatExtractionSummary_Limits(t *testing.T) {
+	result := &ExtractionResult{TargetText: DefaultTargetText}
+	for i := range 30 {
+		name := fmt.Sprintf("file%02d.xlsx / Sheet1", i)
+		result.Matches = append(result.Matches, ContractMatch{Sheet: name, Row: 1, Cell: "A1", Values: []string{"1", "2"}})
+		result.Sheets = append(result.Sheets, SheetStats{Name: name, Matches: 1})
+	}
+	for i := range 500 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("Sheet1!B%d: formula could not be evaluated: some long explanation", i+1))
+	}
+
+	summary := formatExtractionSummary(result)
+	if strings.Contains(summary, "file25.xlsx / Sheet1: 1") || !strings.Contains(summary, fmt.Sprintf(TextSummaryMore, 10)) {
+		t.Errorf("sheet lines should be capped:\n%s", summary)
+	}
+	if strings.Contains(summary, "B11:") || !strings.Contains(summary, fmt.Sprintf(TextSummaryMore, 490)) {
+		t.Errorf("warnings should be capped:\n%s", summary)
+	}
+
+	long := strings.Repeat("📊", 3000)
+	truncated := truncateMessage(long)
+	if units := len(utf16.Encode([]rune(truncated))); units > telegramMessageLimit || !strings.HasSuffix(truncated, "…") {
+		t.Errorf("truncated message has %d UTF-16 units", units)
+	}
+	if truncateMessage("short") != "short" {
+		t.Error("short messages should be left unchanged")
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// resolveFormat picks the output format for a request: a format name in the
+// request text wins, then the user's saved default, then SQL.
+func (h *Handler) resolveFormat(userID int64, requestText string) string {
+	if format, ok := h.parseFormat(requestText); ok {
+		return format
+	}
+
+	if h.preferences != nil {
+		if format := h.preferences.Get(userID).Format; format != "" {
+			if _, ok := h.outputWriterFor(format); ok {
+				return format
+			}
+		}
+	}
+
+	return DefaultTemplateName
+}
+
+// parseFormat returns the output format named in text: a "format=<name>"
+// word, or a text that is nothing but the format name. Bare format names in
+// longer captions are ignored, so "results for March" does not run a query.
+func (h *Handler) parseFormat(text string) (string, bool) {
+	words := strings.Fields(strings.ToLower(text))
+	for _, word := range words {
+		name, found := strings.CutPrefix(word, "format=")
+		if !found {
+			continue
+		}
+		if writer, ok := h.outputWriterFor(name); ok {
+			return writer.Name(), true
+		}
+	}
+
+	if len(words) == 1 {
+		if writer, ok := h.outputWriterFor(words[0]); ok {
+			return writer.Name(), true
+		}
+	}
+	return "", false
+}
+
+func (h *Handler) handleFormatCommand(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatID := update.Message.Chat.ID
+	userID := update.Message.From.ID
+
+	if format, ok := h.parseFormat(update.Message.CommandArguments()); ok {
+		h.setDefaultFormat(bot, chatID, userID, format)
+		return
+	}
+
+	current := h.resolveFormat(userID, "")
+	text := fmt.Sprintf(TextFormatCurrent, current, strings.Join(h.outputFormatNames(), ", "))
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, name := range h.outputFormatNames() {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(name, CallbackFormatPrefix+name))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+}
+
+func (h *Handler) handleFormatCallback(query *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	format := strings.TrimPrefix(query.Data, CallbackFormatPrefix)
+	if _, ok := h.outputWriterFor(format); !ok {
+		log.Printf("Unknown output format in callback: %s", format)
+		return
+	}
+
+	h.setDefaultFormat(bot, query.Message.Chat.ID, query.From.ID, format)
+}
+
+func (h *Handler) setDefaultFormat(bot *tgbotapi.BotAPI, chatID, userID int64, format string) {
+	if h.preferences == nil {
+		msg := tgbotapi.NewMessage(chatID, TextFormatSaveError)
+		bot.Send(msg)
+		return
+	}
+
+	err := h.preferences.Update(userID, func(prefs *UserPreferences) {
+		prefs.Format = format
+	})
+	if err != nil {
+		log.Printf("Error saving preferences for user %d: %v", userID, err)
+		msg := tgbotapi.NewMessage(chatID, TextFormatSaveError)
+		bot.Send(msg)
+		return
+	}
+
+	log.Printf("Default output format for user %d set to %s", userID, format)
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextFormatSaved, format))
+	bot.Send(msg)
+}
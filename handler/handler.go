@@ -1,30 +1,96 @@
 package handler
 
 import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/extrame/xls"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/xuri/excelize/v2"
 )
 
 type Handler struct {
-	userStates map[int64]string
+	userStates  map[int64]string
+	config      Config
+	httpClient  *http.Client
+	history     *HistoryStore
+	preferences *PreferencesStore
+	queryRunner *QueryRunner
+	rules       []Rule
+
+	pendingDuplicates map[int64]HistoryRecord
+	diffBases         map[int64]diffBase
+	pendingPasswords  map[int64]pendingPassword
+	sheetPicks        map[int64]*sheetPick
+	mediaGroups       *mediaGroupCollector
 }
 
 func NewHandler() *Handler {
-	return &Handler{
-		userStates: make(map[int64]string),
+	return NewHandlerWithConfig(LoadConfig())
+}
+
+func NewHandlerWithConfig(config Config) *Handler {
+	history, err := NewHistoryStore(config.HistoryPath)
+	if err != nil {
+		log.Printf("Error loading processing history: %v", err)
+	}
+
+	preferences, err := NewPreferencesStore(config.PreferencesPath)
+	if err != nil {
+		log.Printf("Error loading user preferences: %v", err)
+	}
+
+	var queryRunner *QueryRunner
+	if config.DBDriver != "" && config.DBDSN != "" {
+		db, err := sql.Open(config.DBDriver, config.DBDSN)
+		if err != nil {
+			log.Printf("Error opening %s database: %v", config.DBDriver, err)
+		} else {
+			queryRunner = NewQueryRunner(db, config.QueryTimeout, config.QueryMaxRows)
+		}
 	}
+
+	rules, err := LoadRules(config.RulesPath, config.ContractPattern)
+	if err != nil {
+		log.Printf("Error loading extraction rules, using the default rule: %v", err)
+		rules, err = LoadRules("", DefaultContractPattern)
+		if err != nil {
+			log.Printf("Error loading the default rule: %v", err)
+		}
+	}
+
+	h := &Handler{
+		userStates:  make(map[int64]string),
+		config:      config,
+		httpClient:  &http.Client{Timeout: config.DownloadTimeout},
+		history:     history,
+		preferences: preferences,
+		queryRunner: queryRunner,
+		rules:       rules,
+
+		pendingDuplicates: make(map[int64]HistoryRecord),
+		diffBases:         make(map[int64]diffBase),
+		pendingPasswords:  make(map[int64]pendingPassword),
+		sheetPicks:        make(map[int64]*sheetPick),
+	}
+	h.mediaGroups = newMediaGroupCollector(config.MediaGroupWindow, h.processMediaGroup)
+
+	return h
 }
 
 func (h *Handler) HandleUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	if update.CallbackQuery != nil {
+		h.handleCallbackQuery(update, bot)
+		return
+	}
+
 	if update.Message == nil {
 		return
 	}
@@ -48,6 +114,14 @@ func (h *Handler) handleCommandMessages(update tgbotapi.Update, bot *tgbotapi.Bo
 	switch command {
 	case "start":
 		h.handleStartCommand(update, bot)
+	case "history":
+		h.handleHistoryCommand(update, bot)
+	case "rerun":
+		h.handleRerunCommand(update, bot)
+	case "diff":
+		h.handleDiffCommand(update, bot)
+	case "format":
+		h.handleFormatCommand(update, bot)
 	default:
 		log.Printf("Unknown command: %s", command)
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, TextUnknownCommand)
@@ -55,6 +129,49 @@ func (h *Handler) handleCommandMessages(update tgbotapi.Update, bot *tgbotapi.Bo
 	}
 }
 
+func (h *Handler) handleCallbackQuery(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	query := update.CallbackQuery
+
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		log.Printf("Error answering callback query: %v", err)
+	}
+
+	if query.Message == nil {
+		return
+	}
+
+	log.Printf("Received callback from chat %d: %s", query.Message.Chat.ID, query.Data)
+
+	// The sheet picker keeps its keyboard while sheets are toggled.
+	if strings.HasPrefix(query.Data, CallbackSheetPrefix) {
+		h.handleSheetCallback(query, bot)
+		return
+	}
+
+	h.removeKeyboard(bot, query.Message)
+
+	switch {
+	case query.Data == CallbackDuplicateResend, query.Data == CallbackDuplicateProcess:
+		h.handleDuplicateCallback(query, bot)
+	case strings.HasPrefix(query.Data, CallbackFormatPrefix):
+		h.handleFormatCallback(query, bot)
+	default:
+		log.Printf("Unknown callback data: %s", query.Data)
+	}
+}
+
+// removeKeyboard takes the buttons off a message once its choice is made.
+func (h *Handler) removeKeyboard(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	removeButtons := tgbotapi.NewEditMessageReplyMarkup(
+		message.Chat.ID,
+		message.MessageID,
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}},
+	)
+	if _, err := bot.Request(removeButtons); err != nil {
+		log.Printf("Error removing inline keyboard: %v", err)
+	}
+}
+
 func (h *Handler) handleStartCommand(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	chatID := update.Message.Chat.ID
 	username := update.Message.From.FirstName
@@ -64,7 +181,7 @@ func (h *Handler) handleStartCommand(update tgbotapi.Update, bot *tgbotapi.BotAP
 
 	h.setState(chatID, StateStart)
 
-	welcomeText := GetWelcomeText(username)
+	welcomeText := GetWelcomeText(username, h.outputFormatNames())
 	msg := tgbotapi.NewMessage(chatID, welcomeText)
 
 	if _, err := bot.Send(msg); err != nil {
@@ -78,13 +195,21 @@ func (h *Handler) handleTextMessages(update tgbotapi.Update, bot *tgbotapi.BotAP
 	chatID := update.Message.Chat.ID
 	text := update.Message.Text
 
-	log.Printf("Received text message from chat %d: %s", chatID, text)
-
 	state := h.getState(chatID)
+	if state == StateAwaitingPassword {
+		log.Printf("Received workbook password from chat %d", chatID)
+		h.handlePasswordText(update, bot)
+		return
+	}
+
+	log.Printf("Received text message from chat %d: %s", chatID, text)
 
 	switch state {
 	case StateStart:
 		h.handleStartStateText(update, bot)
+	case StateAwaitingDiffBase, StateAwaitingDiffTarget:
+		msg := tgbotapi.NewMessage(chatID, TextDiffAwaitingFile)
+		bot.Send(msg)
 	default:
 		h.handleDefaultText(update, bot)
 	}
@@ -97,7 +222,7 @@ func (h *Handler) handleStartStateText(update tgbotapi.Update, bot *tgbotapi.Bot
 		username = update.Message.From.UserName
 	}
 
-	welcomeText := GetWelcomeText(username)
+	welcomeText := GetWelcomeText(username, h.outputFormatNames())
 	msg := tgbotapi.NewMessage(chatID, welcomeText)
 
 	if _, err := bot.Send(msg); err != nil {
@@ -122,30 +247,45 @@ func (h *Handler) handleFileMessages(update tgbotapi.Update, bot *tgbotapi.BotAP
 
 	log.Printf("Received file from chat %d: %s", chatID, document.FileName)
 
-	if !h.isValidExcelFile(document.FileName) {
+	if hasExtension(document.FileName, binaryWorkbookExtensions) {
+		log.Printf("Unsupported binary workbook received: %s", document.FileName)
+		msg := tgbotapi.NewMessage(chatID, TextFileBinaryUnsupported)
+		bot.Send(msg)
+		return
+	}
+
+	if !h.isValidExcelFile(document.FileName) && !isArchiveFile(document.FileName) {
 		log.Printf("Invalid file type received: %s", document.FileName)
 		msg := tgbotapi.NewMessage(chatID, TextFileInvalidType)
 		bot.Send(msg)
 		return
 	}
 
+	if int64(document.FileSize) > h.config.MaxFileSize {
+		log.Printf("File too large: %s (%d bytes)", document.FileName, document.FileSize)
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextFileTooLarge, h.config.MaxFileSize/(1024*1024)))
+		bot.Send(msg)
+		return
+	}
+
 	fileURL, err := bot.GetFileDirectURL(document.FileID)
 	if err != nil {
-		log.Printf("Error getting file URL: %v", err)
+		log.Printf("Error getting file URL: %v", withoutURL(err))
 		msg := tgbotapi.NewMessage(chatID, TextFileDownloadError)
 		bot.Send(msg)
 		return
 	}
 
-	filePath, err := h.downloadAndSaveFile(fileURL, document.FileName)
+	download, err := h.downloadAndSaveFile(context.Background(), fileURL, document.FileName)
 	if err != nil {
 		log.Printf("Error downloading/saving file: %v", err)
-		msg := tgbotapi.NewMessage(chatID, TextFileSaveError)
+		msg := tgbotapi.NewMessage(chatID, h.downloadErrorText(err))
 		bot.Send(msg)
 		return
 	}
 
-	log.Printf("File saved successfully: %s", filePath)
+	filePath := download.Path
+	log.Printf("File saved successfully: %s (%d bytes, sha256=%s)", filePath, download.Size, download.SHA256)
 
 	fileSizeKB := float64(document.FileSize) / 1024
 	responseText := TextFileReceived
@@ -158,227 +298,313 @@ func (h *Handler) handleFileMessages(update tgbotapi.Update, bot *tgbotapi.BotAP
 		log.Printf("Error sending message: %v", err)
 	}
 
-	textContent, err := h.readExcelFile(filePath)
-	if err != nil {
-		log.Printf("Error reading Excel file: %v", err)
-		msg := tgbotapi.NewMessage(chatID, TextFileReadError)
-		bot.Send(msg)
+	upload := HistoryRecord{
+		ChatID:     chatID,
+		UserID:     update.Message.From.ID,
+		Username:   update.Message.From.UserName,
+		FileName:   document.FileName,
+		FilePath:   filePath,
+		SHA256:     download.SHA256,
+		Rule:       h.resolveRule(update.Message.Caption, "").Name,
+		Template:   h.resolveFormat(update.Message.From.ID, update.Message.Caption),
+		UploadedAt: time.Unix(int64(update.Message.Date), 0),
+	}
+	upload.Targets = requestTargets(update.Message.Caption)
+	upload.Duplicates, upload.Order = requestArrangement(update.Message.Caption)
+
+	switch h.getState(chatID) {
+	case StateAwaitingDiffBase, StateAwaitingDiffTarget:
+		h.handleDiffUpload(bot, upload)
 		return
 	}
 
-	err = h.sendTextFileToUser(bot, chatID, textContent)
-	if err != nil {
-		log.Printf("Error sending file to user: %v", err)
+	if groupID := update.Message.MediaGroupID; groupID != "" && !isArchiveFile(upload.FileName) {
+		h.collectMediaGroup(bot, groupID, upload)
 		return
 	}
 
-	log.Printf("Successfully sent script.txt to user %d", chatID)
-}
+	if wantsSheetPicker(update.Message.Caption) && h.offerSheetPicker(bot, upload) {
+		return
+	}
 
-func (h *Handler) isValidExcelFile(fileName string) bool {
-	lowerName := strings.ToLower(fileName)
-	return strings.HasSuffix(lowerName, ".xls") || strings.HasSuffix(lowerName, ".xlsx")
+	if h.offerCachedOutput(bot, upload) {
+		return
+	}
+
+	h.processUpload(bot, upload)
 }
 
-func (h *Handler) downloadAndSaveFile(fileURL, fileName string) (string, error) {
-	filesDir := "files"
-	if err := os.MkdirAll(filesDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create files directory: %w", err)
+// processUpload extracts contracts from the stored file, sends the output and
+// records the run in history, asking for the password first when the
+// workbook is encrypted. The record's ResultCount and OutputPath are filled
+// in here.
+func (h *Handler) processUpload(bot *tgbotapi.BotAPI, upload HistoryRecord) {
+	if isArchiveFile(upload.FileName) {
+		h.processArchive(bot, upload)
+		return
 	}
 
-	resp, err := http.Get(fileURL)
+	encrypted, err := isEncryptedWorkbook(upload.FilePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to download file: %w", err)
+		log.Printf("Error checking %s for encryption: %v", upload.FilePath, err)
+	}
+	if encrypted {
+		h.requestPassword(bot, upload)
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %s", resp.Status)
+	h.processWorkbook(bot, upload, "")
+}
+
+// processWorkbook returns ErrWrongPassword when the password does not open
+// the workbook; every other failure is reported to the user directly.
+func (h *Handler) processWorkbook(bot *tgbotapi.BotAPI, upload HistoryRecord, password string) error {
+	writer, found := h.outputWriterFor(upload.Template)
+	if !found {
+		writer = SQLWriter{}
+		upload.Template = writer.Name()
 	}
 
-	filePath := filepath.Join(filesDir, fileName)
-	outFile, err := os.Create(filePath)
+	rule := h.uploadRule(upload)
+	upload.Rule = rule.Name
+
+	result, err := h.readWorkbook(upload.FilePath, rule, password)
+	if errors.Is(err, ErrWrongPassword) {
+		return err
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
+		log.Printf("Error reading Excel file: %v", err)
+		msg := tgbotapi.NewMessage(upload.ChatID, readErrorText(err))
+		bot.Send(msg)
+		return nil
 	}
-	defer outFile.Close()
 
-	_, err = io.Copy(outFile, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	resultCount, outputPath, ok := h.sendExtraction(bot, upload.ChatID, upload.FilePath, formatExtractionSummary(result), result, writer)
+	if !ok {
+		return nil
 	}
 
-	return filePath, nil
+	upload.ResultCount = resultCount
+	upload.OutputPath = outputPath
+	h.recordHistory(upload)
+	return nil
 }
 
-func (h *Handler) readExcelFile(filePath string) (string, error) {
-	lowerPath := strings.ToLower(filePath)
+// sendExtraction sends the summary text and then the rendered output, and
+// stores the output next to the upload.
+func (h *Handler) sendExtraction(bot *tgbotapi.BotAPI, chatID int64, filePath, summary string, result *ExtractionResult, writer OutputWriter) (int, string, bool) {
+	msg := tgbotapi.NewMessage(chatID, truncateMessage(summary))
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+
+	if result.Outcome() == OutcomeNoMatches {
+		log.Printf("No matching data found in %s", filePath)
+		return 0, "", true
+	}
+
+	content, err := renderOutput(writer, result)
+	if err != nil {
+		log.Printf("Error rendering %s output: %v", writer.Name(), err)
+		msg := tgbotapi.NewMessage(chatID, outputErrorText(writer, err))
+		bot.Send(msg)
+		return len(result.Matches), "", false
+	}
+
+	err = h.sendDocument(bot, chatID, writer.FileName(), content, TextFileProcessed)
+	if err != nil {
+		log.Printf("Error sending file to user: %v", err)
+		return len(result.Matches), "", false
+	}
+
+	log.Printf("Successfully sent %s to user %d", writer.FileName(), chatID)
 
-	if strings.HasSuffix(lowerPath, ".xls") {
-		return h.readXlsFile(filePath)
+	outputPath, err := h.saveOutput(filePath, writer.FileName(), content)
+	if err != nil {
+		log.Printf("Error saving output for %s: %v", filePath, err)
 	}
 
-	return h.readXlsxFile(filePath)
+	return len(result.Matches), outputPath, true
 }
 
-func (h *Handler) readXlsxFile(filePath string) (string, error) {
-	f, err := excelize.OpenFile(filePath)
+func (h *Handler) recordHistory(record HistoryRecord) {
+	if h.history == nil {
+		return
+	}
+
+	record.ProcessedAt = time.Now()
+	saved, err := h.history.Add(record)
 	if err != nil {
-		return "", fmt.Errorf("failed to open Excel file: %w", err)
+		log.Printf("Error recording history for %s: %v", record.FileName, err)
+		return
 	}
-	defer f.Close()
 
-	var results []string
-	targetText := "ББС ІНШУРАНС"
+	log.Printf("Recorded history entry #%d for user %d: %s", saved.ID, saved.UserID, saved.FileName)
+}
+
+func (h *Handler) handleHistoryCommand(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatID := update.Message.Chat.ID
 
-	sheetList := f.GetSheetList()
-	for _, sheetName := range sheetList {
-		rows, err := f.GetRows(sheetName)
-		if err != nil {
-			log.Printf("Error reading sheet %s: %v", sheetName, err)
-			continue
-		}
+	var records []HistoryRecord
+	if h.history != nil {
+		records = h.history.ListByUser(update.Message.From.ID, h.config.HistoryLimit)
+	}
 
-		for _, row := range rows {
-			for colIndex, cell := range row {
-				if strings.Contains(cell, targetText) {
-					if colIndex+2 < len(row) {
-						firstValue := row[colIndex+1]
-						secondValue := row[colIndex+2]
-						combined := firstValue + "-" + secondValue
-						results = append(results, combined)
-						log.Printf("Found match in sheet %s: %s", sheetName, combined)
-					}
-					break
-				}
-			}
-		}
+	if len(records) == 0 {
+		msg := tgbotapi.NewMessage(chatID, TextHistoryEmpty)
+		bot.Send(msg)
+		return
 	}
 
-	if len(results) == 0 {
-		return "No matching data found for 'ББС ІНШУРАНС'", nil
+	text := TextHistoryHeader
+	for _, record := range records {
+		text += fmt.Sprintf(TextHistoryItem,
+			record.ID,
+			record.ProcessedAt.Format("2006-01-02 15:04"),
+			record.FileName,
+			record.ResultCount,
+		)
 	}
+	text += TextHistoryFooter
 
-	content := h.generateSQLScript(results)
-	return content, nil
+	msg := tgbotapi.NewMessage(chatID, text)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
 }
 
-func (h *Handler) readXlsFile(filePath string) (string, error) {
-	xlsFile, err := xls.Open(filePath, "utf-8")
-	if err != nil {
-		return "", fmt.Errorf("failed to open XLS file: %w", err)
+func (h *Handler) handleRerunCommand(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatID := update.Message.Chat.ID
+
+	record, ok := h.lookupUserRecord(update, bot, TextRerunUsage)
+	if !ok {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextRerunProcessing, record.ID, record.FileName))
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
 	}
 
-	var results []string
-	targetText := "ББС ІНШУРАНС"
+	options := strings.Join(rerunOptions(update), " ")
+	duplicates, order := requestArrangement(options)
 
-	for sheetIndex := 0; sheetIndex < xlsFile.NumSheets(); sheetIndex++ {
-		sheet := xlsFile.GetSheet(sheetIndex)
-		if sheet == nil {
-			continue
+	// The format may follow the id on its own, as in "/rerun 12 csv".
+	formatText := options
+	if args := rerunOptions(update); len(args) > 0 {
+		if _, ok := h.outputWriterFor(args[0]); ok {
+			formatText = args[0]
 		}
+	}
 
-		maxRow := int(sheet.MaxRow)
-		for rowIndex := 0; rowIndex <= maxRow; rowIndex++ {
-			row := sheet.Row(rowIndex)
-			if row == nil {
-				continue
-			}
-
-			maxCol := row.LastCol()
-			for colIndex := row.FirstCol(); colIndex < maxCol; colIndex++ {
-				cellContent := row.Col(colIndex)
-				if strings.Contains(cellContent, targetText) {
-					if colIndex+2 < maxCol {
-						firstValue := row.Col(colIndex + 1)
-						secondValue := row.Col(colIndex + 2)
-						combined := firstValue + "-" + secondValue
-						results = append(results, combined)
-						log.Printf("Found match in sheet %s: %s", sheet.Name, combined)
-					}
-					break
-				}
-			}
-		}
+	h.processUpload(bot, HistoryRecord{
+		ChatID:     chatID,
+		UserID:     update.Message.From.ID,
+		Username:   update.Message.From.UserName,
+		FileName:   record.FileName,
+		FilePath:   record.FilePath,
+		SHA256:     record.SHA256,
+		Rule:       h.resolveRule(options, record.Rule).Name,
+		Template:   h.resolveFormat(update.Message.From.ID, formatText),
+		Targets:    record.Targets,
+		Sheets:     record.Sheets,
+		Duplicates: cmp.Or(duplicates, record.Duplicates),
+		Order:      cmp.Or(order, record.Order),
+		RerunOf:    record.ID,
+		UploadedAt: record.UploadedAt,
+	})
+}
+
+// lookupUserRecord resolves the history id given as command argument to one of
+// the sender's own records whose stored file still exists. The user is told
+// what went wrong when it returns false.
+func (h *Handler) lookupUserRecord(update tgbotapi.Update, bot *tgbotapi.BotAPI, usageText string) (HistoryRecord, bool) {
+	chatID := update.Message.Chat.ID
+	args := strings.Fields(update.Message.CommandArguments())
+	if len(args) == 0 {
+		msg := tgbotapi.NewMessage(chatID, usageText)
+		bot.Send(msg)
+		return HistoryRecord{}, false
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, usageText)
+		bot.Send(msg)
+		return HistoryRecord{}, false
 	}
 
-	if len(results) == 0 {
-		return "No matching data found for 'ББС ІНШУРАНС'", nil
+	var record HistoryRecord
+	found := false
+	if h.history != nil {
+		record, found = h.history.Get(id)
+	}
+
+	if !found || record.UserID != update.Message.From.ID {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextRerunNotFound, id))
+		bot.Send(msg)
+		return HistoryRecord{}, false
 	}
 
-	content := h.generateSQLScript(results)
-	return content, nil
+	if _, err := os.Stat(record.FilePath); err != nil {
+		log.Printf("Stored file for history entry #%d is unavailable: %v", id, err)
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextRerunFileMissing, record.FileName))
+		bot.Send(msg)
+		return HistoryRecord{}, false
+	}
+
+	return record, true
 }
 
-func (h *Handler) generateSQLScript(contracts []string) string {
-	var sql strings.Builder
-
-	// SQL SELECT clause
-	sql.WriteString("SELECT \n")
-	sql.WriteString("    sort_order.number AS 'Номер договору',\n")
-	sql.WriteString("    dbo.getCagentFullName(c.id_acquisitor) AS 'Аквізитор',\n")
-	sql.WriteString("    dbo.getCagentFullName(c.id_responsible) AS 'Відповідальна особа',\n")
-	sql.WriteString("    (\n")
-	sql.WriteString("        SELECT \n")
-	sql.WriteString("            CASE \n")
-	sql.WriteString("                WHEN sch.id_parent IS NULL THEN ISNULL(sch.name, '') \n")
-	sql.WriteString("                ELSE ISNULL(\n")
-	sql.WriteString("                    (SELECT csch.name FROM sale_channel csch WHERE csch.id = sch.id_parent), \n")
-	sql.WriteString("                    ''\n")
-	sql.WriteString("                ) \n")
-	sql.WriteString("            END \n")
-	sql.WriteString("        FROM sale_channel sch \n")
-	sql.WriteString("        WHERE sch.id = c.id_saleChannel\n")
-	sql.WriteString("    ) AS 'Канал продажів',\n")
-	sql.WriteString("    (\n")
-	sql.WriteString("        SELECT \n")
-	sql.WriteString("            CASE \n")
-	sql.WriteString("                WHEN sch.id_parent IS NULL THEN '' \n")
-	sql.WriteString("                ELSE ISNULL(sch.name, '') \n")
-	sql.WriteString("            END \n")
-	sql.WriteString("        FROM sale_channel sch \n")
-	sql.WriteString("        WHERE sch.id = c.id_saleChannel\n")
-	sql.WriteString("    ) AS 'Підканал продажів',\n")
-	sql.WriteString("    ISNULL(div.name, '') AS 'Обліковий підрозділ',\n")
-	sql.WriteString("    (\n")
-	sql.WriteString("        SELECT \n")
-	sql.WriteString("            CASE \n")
-	sql.WriteString("                WHEN h_div.id_parent IS NULL THEN '' \n")
-	sql.WriteString("                ELSE ISNULL(p_div.name, '') \n")
-	sql.WriteString("            END \n")
-	sql.WriteString("        FROM division p_div \n")
-	sql.WriteString("        WHERE p_div.id = h_div.id_parent\n")
-	sql.WriteString("    ) AS 'Вищестоящий підрозділ'\n")
-	sql.WriteString("FROM \n")
-	sql.WriteString("    (VALUES \n")
-
-	// VALUES clause with contracts
-	for index, contract := range contracts {
-		if index > 0 {
-			sql.WriteString(",\n")
-		}
-		sql.WriteString(fmt.Sprintf("        ('EP-%s', %d)", contract, index))
+// rerunOptions returns the command arguments following the history id.
+func rerunOptions(update tgbotapi.Update) []string {
+	args := strings.Fields(update.Message.CommandArguments())
+	if len(args) < 2 {
+		return nil
 	}
+	return args[1:]
+}
 
-	// Closing SQL
-	sql.WriteString("\n    ) AS sort_order(number, sort_seq)\n")
-	sql.WriteString("LEFT JOIN contract c ON c.number = sort_order.number\n")
-	sql.WriteString("LEFT JOIN division div ON div.id = c.id_division\n")
-	sql.WriteString("LEFT JOIN helement h_div ON h_div.id = div.id\n")
-	sql.WriteString("ORDER BY \n")
-	sql.WriteString("    sort_order.sort_seq;")
+func (h *Handler) downloadErrorText(err error) string {
+	switch {
+	case errors.Is(err, ErrFileTooLarge):
+		return fmt.Sprintf(TextFileTooLarge, h.config.MaxFileSize/(1024*1024))
+	case errors.Is(err, ErrInvalidSignature):
+		return TextFileInvalidContent
+	case errors.Is(err, ErrUnsupportedFormat):
+		return TextFileBinaryUnsupported
+	case errors.Is(err, context.DeadlineExceeded):
+		return TextFileDownloadTimeout
+	default:
+		return TextFileSaveError
+	}
+}
 
-	return sql.String()
+// outputErrorText tells apart a database query that failed or timed out
+// from a writer that could not produce its file.
+func outputErrorText(writer OutputWriter, err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return TextQueryTimeout
+	case writer.Name() == FormatResults:
+		return TextQueryFailed
+	default:
+		return TextOutputError
+	}
+}
+
+func (h *Handler) isValidExcelFile(fileName string) bool {
+	return hasExtension(fileName, workbookExtensions)
 }
 
-func (h *Handler) sendTextFileToUser(bot *tgbotapi.BotAPI, chatID int64, content string) error {
+func (h *Handler) sendDocument(bot *tgbotapi.BotAPI, chatID int64, name string, content []byte, caption string) error {
 	fileBytes := tgbotapi.FileBytes{
-		Name:  "script.txt",
-		Bytes: []byte(content),
+		Name:  name,
+		Bytes: content,
 	}
 
 	doc := tgbotapi.NewDocument(chatID, fileBytes)
-	doc.Caption = TextFileProcessed
+	doc.Caption = caption
 
 	_, err := bot.Send(doc)
 	if err != nil {
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -43,6 +44,10 @@ func TestIsValidExcelFile(t *testing.T) {
 		{"valid xlsx with path", "/path/to/file.xlsx", true},
 		{"valid xls with spaces", "my file.xls", true},
 		{"valid xlsx with cyrillic", "файл.xlsx", true},
+		{"valid macro-enabled workbook", "macros.xlsm", true},
+		{"valid template", "template.XLTX", true},
+		{"valid macro-enabled template", "template.xltm", true},
+		{"binary workbook is not accepted", "binary.xlsb", false},
 	}
 
 	for _, tt := range tests {
@@ -55,12 +60,27 @@ func TestIsValidExcelFile(t *testing.T) {
 	}
 }
 
-func TestGenerateSQLScript(t *testing.T) {
-	h := NewHandler()
+// writeSQLScript renders contracts of the default rule ("228960453-123")
+// through SQLWriter, the way the bot writes its SQL output.
+func writeSQLScript(tb testing.TB, style string, contracts ...string) string {
+	tb.Helper()
+
+	result := newExtractionResult("test.xlsx", builtinRule(""))
+	for _, contract := range contracts {
+		result.Matches = append(result.Matches, ContractMatch{Values: strings.SplitN(contract, "-", 2)})
+	}
+
+	var sql strings.Builder
+	if err := (SQLWriter{Style: style}).Write(&sql, result); err != nil {
+		tb.Fatalf("Write failed: %v", err)
+	}
+	return sql.String()
+}
 
+func TestSQLWriter_Script(t *testing.T) {
 	t.Run("single contract", func(t *testing.T) {
 		contracts := []string{"228960453-123"}
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
 		// Check SELECT clause present
 		if !strings.Contains(result, "SELECT") {
@@ -85,7 +105,7 @@ func TestGenerateSQLScript(t *testing.T) {
 
 	t.Run("multiple contracts", func(t *testing.T) {
 		contracts := []string{"111111-aaa", "222222-bbb", "333333-ccc"}
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
 		// Check all contracts present with correct indices
 		if !strings.Contains(result, "('EP-111111-aaa', 0)") {
@@ -106,7 +126,7 @@ func TestGenerateSQLScript(t *testing.T) {
 
 	t.Run("empty contracts", func(t *testing.T) {
 		contracts := []string{}
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
 		// Should still have valid SQL structure
 		if !strings.Contains(result, "SELECT") {
@@ -119,7 +139,7 @@ func TestGenerateSQLScript(t *testing.T) {
 
 	t.Run("SQL structure validation", func(t *testing.T) {
 		contracts := []string{"123-456"}
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
 		// Validate required columns
 		expectedColumns := []string{
@@ -153,13 +173,89 @@ func TestGenerateSQLScript(t *testing.T) {
 	})
 }
 
+func TestSQLWriter_TempTable(t *testing.T) {
+	t.Run("structure", func(t *testing.T) {
+		result := writeSQLScript(t, SQLStyleTempTable, "228960453-123", "228960454-456")
+
+		expected := []string{
+			"INTO #contracts\nFROM contract c;",
+			"ALTER TABLE #contracts ADD\n    PRIMARY KEY (sort_seq);",
+			"INSERT INTO #contracts (number, sort_seq) VALUES \n        ('EP-228960453-123', 0),\n        ('EP-228960454-456', 1);",
+			"FROM \n    #contracts sort_order\n",
+			"LEFT JOIN contract c ON c.number = sort_order.number",
+			"ORDER BY \n    sort_order.sort_seq;",
+		}
+		for _, want := range expected {
+			if !strings.Contains(result, want) {
+				t.Errorf("Result should contain %q:\n%s", want, result)
+			}
+		}
+		if !strings.HasSuffix(result, "DROP TABLE #contracts;") {
+			t.Error("Script should end by dropping the temporary table")
+		}
+		if strings.Contains(result, "(VALUES") {
+			t.Error("Temp table script should not use an inline VALUES table")
+		}
+	})
+
+	t.Run("batched inserts", func(t *testing.T) {
+		numbers := make([]string, 5)
+		for i := range numbers {
+			numbers[i] = fmt.Sprintf("EP-%d-1", i)
+		}
+		columns := []sqlColumn{{Name: "premium", Label: "premium", Type: ColumnTypeNumber, Values: []string{"1", "2", "", "4", "5"}}}
+
+		result := buildTempTableScript(numbers, 2, columns...)
+		if got := strings.Count(result, "INSERT INTO #contracts (number, sort_seq, [premium])"); got != 3 {
+			t.Errorf("Expected 3 INSERT batches, got %d:\n%s", got, result)
+		}
+		if !strings.Contains(result, "('EP-2-1', 2, NULL),\n        ('EP-3-1', 3, 4);") {
+			t.Errorf("sort_seq and values should continue across batches:\n%s", result)
+		}
+		if !strings.Contains(result, "[premium] decimal(38, 10) NULL") {
+			t.Errorf("Typed column missing from the table definition:\n%s", result)
+		}
+	})
+
+	t.Run("collation", func(t *testing.T) {
+		columns := []sqlColumn{
+			{Name: "client", Label: "client", Type: ColumnTypeText, Values: []string{"Ivanenko"}},
+			{Name: "premium", Label: "premium", Type: ColumnTypeNumber, Values: []string{"1"}},
+		}
+		result := buildTempTableScript([]string{"EP-1-1"}, sqlInsertBatchSize, columns...)
+
+		// The number column copies contract.number rather than a fixed
+		// nvarchar length, and it and the text columns use the database
+		// collation so the join works whatever tempdb's collation is.
+		for _, want := range []string{
+			"    c.number COLLATE DATABASE_DEFAULT AS number,\n",
+			"[client] nvarchar(400) COLLATE DATABASE_DEFAULT NULL",
+			"[premium] decimal(38, 10) NULL",
+		} {
+			if !strings.Contains(result, want) {
+				t.Errorf("Result should contain %q:\n%s", want, result)
+			}
+		}
+		if strings.Contains(result, "nvarchar(100)") {
+			t.Errorf("The number column should not have a fixed length:\n%s", result)
+		}
+	})
+
+	t.Run("writer", func(t *testing.T) {
+		writer := SQLWriter{Style: SQLStyleTempTable}
+		if writer.Name() != FormatSQLTempTable || (SQLWriter{}).Name() != FormatSQL {
+			t.Errorf("Unexpected writer names %q, %q", writer.Name(), SQLWriter{}.Name())
+		}
+	})
+}
+
 func TestReadExcelFile_Routing(t *testing.T) {
 	h := NewHandler()
 
 	// Test that readExcelFile routes to correct reader based on extension
 	t.Run("xlsx extension routing", func(t *testing.T) {
 		// This will fail because file doesn't exist, but we can verify error message
-		_, err := h.readExcelFile("test.xlsx")
+		_, err := h.readExcelFile("test.xlsx", h.defaultRule())
 		if err == nil {
 			t.Error("Expected error for non-existent file")
 		}
@@ -170,7 +266,7 @@ func TestReadExcelFile_Routing(t *testing.T) {
 	})
 
 	t.Run("xls extension routing", func(t *testing.T) {
-		_, err := h.readExcelFile("test.xls")
+		_, err := h.readExcelFile("test.xls", h.defaultRule())
 		if err == nil {
 			t.Error("Expected error for non-existent file")
 		}
@@ -209,24 +305,33 @@ func TestReadXlsxFile_Integration(t *testing.T) {
 		}
 
 		// Read the file
-		result, err := h.readXlsxFile(testFile)
+		extraction, err := h.readXlsxFile(testFile, h.defaultRule(), "")
 		if err != nil {
 			t.Fatalf("readXlsxFile failed: %v", err)
 		}
 
+		if extraction.Outcome() != OutcomeMatched {
+			t.Errorf("Expected matched outcome, got: %s", extraction.Outcome())
+		}
+
+		result, err := renderOutput(SQLWriter{}, extraction)
+		if err != nil {
+			t.Fatalf("renderOutput failed: %v", err)
+		}
+
 		// Verify SQL contains extracted data
-		if !strings.Contains(result, "('EP-228960453-123', 0)") {
+		if !strings.Contains(string(result), "('EP-228960453-123', 0)") {
 			t.Error("Result should contain first contract")
 		}
-		if !strings.Contains(result, "('EP-228209382-456', 1)") {
+		if !strings.Contains(string(result), "('EP-228209382-456', 1)") {
 			t.Error("Result should contain second contract")
 		}
 
 		// Verify SQL structure
-		if !strings.Contains(result, "SELECT") {
+		if !strings.Contains(string(result), "SELECT") {
 			t.Error("Result should contain SELECT clause")
 		}
-		if !strings.Contains(result, "ORDER BY") {
+		if !strings.Contains(string(result), "ORDER BY") {
 			t.Error("Result should contain ORDER BY clause")
 		}
 	})
@@ -246,14 +351,14 @@ func TestReadXlsxFile_Integration(t *testing.T) {
 		}
 
 		// Read the file
-		result, err := h.readXlsxFile(testFile)
+		result, err := h.readXlsxFile(testFile, h.defaultRule(), "")
 		if err != nil {
 			t.Fatalf("readXlsxFile failed: %v", err)
 		}
 
-		// Should return "no matching data" message
-		if !strings.Contains(result, "No matching data found") {
-			t.Error("Result should indicate no matching data found")
+		// Should report the distinct no-match outcome
+		if result.Outcome() != OutcomeNoMatches {
+			t.Errorf("Expected no-match outcome, got: %s", result.Outcome())
 		}
 	})
 
@@ -277,18 +382,22 @@ func TestReadXlsxFile_Integration(t *testing.T) {
 			t.Fatalf("Failed to save test file: %v", err)
 		}
 
-		result, err := h.readXlsxFile(testFile)
+		result, err := h.readXlsxFile(testFile, h.defaultRule(), "")
 		if err != nil {
 			t.Fatalf("readXlsxFile failed: %v", err)
 		}
 
 		// Should contain data from both sheets
-		if !strings.Contains(result, "EP-111111-aaa") {
+		contracts := strings.Join(result.Contracts(), ",")
+		if !strings.Contains(contracts, "111111-aaa") {
 			t.Error("Result should contain data from Sheet1")
 		}
-		if !strings.Contains(result, "EP-222222-bbb") {
+		if !strings.Contains(contracts, "222222-bbb") {
 			t.Error("Result should contain data from Sheet2")
 		}
+		if len(result.Sheets) != 2 {
+			t.Errorf("Expected stats for 2 sheets, got %d", len(result.Sheets))
+		}
 	})
 
 	t.Run("read xlsx with partial data", func(t *testing.T) {
@@ -305,20 +414,20 @@ func TestReadXlsxFile_Integration(t *testing.T) {
 			t.Fatalf("Failed to save test file: %v", err)
 		}
 
-		result, err := h.readXlsxFile(testFile)
+		result, err := h.readXlsxFile(testFile, h.defaultRule(), "")
 		if err != nil {
 			t.Fatalf("readXlsxFile failed: %v", err)
 		}
 
 		// Should still handle partial data
 		// The result will be "OnlyOne-" since second value is empty
-		if strings.Contains(result, "No matching data found") {
+		if result.Outcome() == OutcomeNoMatches {
 			t.Log("Partial data was not captured - this may be expected behavior")
 		}
 	})
 
 	t.Run("read non-existent file", func(t *testing.T) {
-		_, err := h.readXlsxFile(filepath.Join(testDir, "non_existent.xlsx"))
+		_, err := h.readXlsxFile(filepath.Join(testDir, "non_existent.xlsx"), h.defaultRule(), "")
 		if err == nil {
 			t.Error("Expected error for non-existent file")
 		}
@@ -373,22 +482,20 @@ func TestStateManagement(t *testing.T) {
 	})
 }
 
-func TestGenerateSQLScript_EdgeCases(t *testing.T) {
-	h := NewHandler()
-
+func TestSQLWriter_EdgeCases(t *testing.T) {
 	t.Run("contract with special characters", func(t *testing.T) {
 		contracts := []string{"123-456'789"}
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
-		// Should contain the contract as-is (SQL injection would be handled by parameterized queries)
-		if !strings.Contains(result, "EP-123-456'789") {
-			t.Error("Contract with special chars should be included")
+		// Quotes are doubled so the number stays one string literal
+		if !strings.Contains(result, "('EP-123-456''789', 0)") {
+			t.Error("Contract with special chars should be included as an escaped literal")
 		}
 	})
 
 	t.Run("contract with spaces", func(t *testing.T) {
 		contracts := []string{"123 456-789"}
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
 		if !strings.Contains(result, "EP-123 456-789") {
 			t.Error("Contract with spaces should be included")
@@ -397,7 +504,7 @@ func TestGenerateSQLScript_EdgeCases(t *testing.T) {
 
 	t.Run("contract with unicode", func(t *testing.T) {
 		contracts := []string{"тест-123"}
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
 		if !strings.Contains(result, "EP-тест-123") {
 			t.Error("Contract with unicode should be included")
@@ -410,7 +517,7 @@ func TestGenerateSQLScript_EdgeCases(t *testing.T) {
 			contracts[i] = "123456-789"
 		}
 
-		result := h.generateSQLScript(contracts)
+		result := writeSQLScript(t, SQLStyleInline, contracts...)
 
 		// Check first and last entries
 		if !strings.Contains(result, "('EP-123456-789', 0)") {
@@ -423,13 +530,12 @@ func TestGenerateSQLScript_EdgeCases(t *testing.T) {
 }
 
 // Benchmark tests
-func BenchmarkGenerateSQLScript(b *testing.B) {
-	h := NewHandler()
+func BenchmarkSQLWriter(b *testing.B) {
 	contracts := []string{"228960453-123", "228209382-456", "226833195-789"}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		h.generateSQLScript(contracts)
+		writeSQLScript(b, SQLStyleInline, contracts...)
 	}
 }
 
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type HistoryRecord struct {
+	ID          int       `json:"id"`
+	ChatID      int64     `json:"chat_id"`
+	UserID      int64     `json:"user_id"`
+	Username    string    `json:"username"`
+	FileName    string    `json:"file_name"`
+	FilePath    string    `json:"file_path"`
+	OutputPath  string    `json:"output_path,omitempty"`
+	SHA256      string    `json:"sha256"`
+	Rule        string    `json:"rule"`
+	Template    string    `json:"template"`
+	Targets     []string  `json:"targets,omitempty"`
+	Sheets      []string  `json:"sheets,omitempty"`
+	Duplicates  string    `json:"duplicates,omitempty"`
+	Order       string    `json:"order,omitempty"`
+	ResultCount int       `json:"result_count"`
+	RerunOf     int       `json:"rerun_of,omitempty"`
+	ReusedFrom  int       `json:"reused_from,omitempty"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// HistoryStore keeps processed uploads in a JSON file so that /history and
+// /rerun survive bot restarts. The whole file is rewritten on every change,
+// which is fine for the volume of uploads a single bot sees.
+type HistoryStore struct {
+	path    string
+	mu      sync.Mutex
+	records []HistoryRecord
+	nextID  int
+	// readOnly is set when the file could not be read, so the first save
+	// does not replace the existing history with an empty one.
+	readOnly bool
+}
+
+// ErrHistoryReadOnly is returned by Add when the history file could not be
+// loaded and saving is disabled.
+var ErrHistoryReadOnly = errors.New("history is read-only after a load error")
+
+// NewHistoryStore loads the history file. A file that cannot be parsed is
+// moved aside to <path>.corrupt-<time> and a new history is started; if it
+// cannot be read or moved, the store stays usable but never saves.
+func NewHistoryStore(path string) (*HistoryStore, error) {
+	store := &HistoryStore{
+		path:   path,
+		nextID: 1,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		store.readOnly = true
+		return store, fmt.Errorf("failed to read history file, saving is disabled: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &store.records); err != nil {
+		store.records = nil
+		corruptPath := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+		if renameErr := os.Rename(path, corruptPath); renameErr != nil {
+			store.readOnly = true
+			return store, fmt.Errorf("failed to parse history file, saving is disabled: %w", err)
+		}
+		return store, fmt.Errorf("failed to parse history file, moved it to %s: %w", corruptPath, err)
+	}
+
+	for _, record := range store.records {
+		if record.ID >= store.nextID {
+			store.nextID = record.ID + 1
+		}
+	}
+
+	return store, nil
+}
+
+func (s *HistoryStore) Add(record HistoryRecord) (HistoryRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.ID = s.nextID
+	s.records = append(s.records, record)
+
+	if err := s.save(); err != nil {
+		s.records = s.records[:len(s.records)-1]
+		return HistoryRecord{}, err
+	}
+
+	s.nextID++
+	return record, nil
+}
+
+func (s *HistoryStore) Get(id int) (HistoryRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records {
+		if record.ID == id {
+			return record, true
+		}
+	}
+
+	return HistoryRecord{}, false
+}
+
+// FindBySHA256 returns the most recent record of a file with the given
+// content hash, regardless of who uploaded it.
+func (s *HistoryStore) FindBySHA256(checksum string) (HistoryRecord, bool) {
+	return s.FindLatest(func(record HistoryRecord) bool {
+		return record.SHA256 == checksum
+	})
+}
+
+// FindLatest returns the most recent record accepted by match.
+func (s *HistoryStore) FindLatest(match func(HistoryRecord) bool) (HistoryRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.records) - 1; i >= 0; i-- {
+		if match(s.records[i]) {
+			return s.records[i], true
+		}
+	}
+
+	return HistoryRecord{}, false
+}
+
+// ListByUser returns the newest records of a user first, at most limit of them.
+func (s *HistoryStore) ListByUser(userID int64, limit int) []HistoryRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []HistoryRecord
+	for _, record := range s.records {
+		if record.UserID == userID {
+			records = append(records, record)
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ID > records[j].ID
+	})
+
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	return records
+}
+
+func (s *HistoryStore) save() error {
+	if s.readOnly {
+		return ErrHistoryReadOnly
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode history: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace history file: %w", err)
+	}
+
+	return nil
+}
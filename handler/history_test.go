@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	store, err := NewHistoryStore(path)
+	if err != nil {
+		t.Fatalf("NewHistoryStore failed: %v", err)
+	}
+
+	first, err := store.Add(HistoryRecord{UserID: 1, FileName: "a.xlsx", ResultCount: 2, ProcessedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	second, _ := store.Add(HistoryRecord{UserID: 2, FileName: "b.xlsx"})
+	third, _ := store.Add(HistoryRecord{UserID: 1, FileName: "c.xls"})
+
+	if first.ID != 1 || second.ID != 2 || third.ID != 3 {
+		t.Errorf("Unexpected ids: %d, %d, %d", first.ID, second.ID, third.ID)
+	}
+
+	t.Run("list by user newest first", func(t *testing.T) {
+		records := store.ListByUser(1, 10)
+		if len(records) != 2 {
+			t.Fatalf("Expected 2 records, got %d", len(records))
+		}
+		if records[0].FileName != "c.xls" || records[1].FileName != "a.xlsx" {
+			t.Errorf("Unexpected order: %s, %s", records[0].FileName, records[1].FileName)
+		}
+	})
+
+	t.Run("list respects limit", func(t *testing.T) {
+		if records := store.ListByUser(1, 1); len(records) != 1 {
+			t.Errorf("Expected 1 record, got %d", len(records))
+		}
+	})
+
+	t.Run("records persist across reloads", func(t *testing.T) {
+		reloaded, err := NewHistoryStore(path)
+		if err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+
+		record, found := reloaded.Get(1)
+		if !found {
+			t.Fatal("Record #1 should be found after reload")
+		}
+		if record.FileName != "a.xlsx" || record.ResultCount != 2 {
+			t.Errorf("Unexpected record after reload: %+v", record)
+		}
+
+		next, _ := reloaded.Add(HistoryRecord{UserID: 3})
+		if next.ID != 4 {
+			t.Errorf("Expected next id 4 after reload, got %d", next.ID)
+		}
+	})
+
+	t.Run("find latest by checksum", func(t *testing.T) {
+		store.Add(HistoryRecord{UserID: 5, FileName: "dup.xlsx", SHA256: "abc"})
+		latest, _ := store.Add(HistoryRecord{UserID: 6, FileName: "dup-copy.xlsx", SHA256: "abc"})
+
+		record, found := store.FindBySHA256("abc")
+		if !found {
+			t.Fatal("Record with checksum abc should be found")
+		}
+		if record.ID != latest.ID {
+			t.Errorf("Expected latest record #%d, got #%d", latest.ID, record.ID)
+		}
+
+		if _, found := store.FindBySHA256("missing"); found {
+			t.Error("Unknown checksum should not be found")
+		}
+	})
+
+	t.Run("missing record", func(t *testing.T) {
+		if _, found := store.Get(42); found {
+			t.Error("Record #42 should not exist")
+		}
+	})
+}
+
+func TestHistoryStore_CorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.json")
+	corrupt := []byte(`[{"id": 1, "file_name": "a.xlsx"`)
+	if err := os.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatalf("Failed to write history: %v", err)
+	}
+
+	store, err := NewHistoryStore(path)
+	if err == nil {
+		t.Fatal("NewHistoryStore should report the parse error")
+	}
+	if _, err := store.Add(HistoryRecord{FileName: "b.xlsx"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	moved, _ := filepath.Glob(path + ".corrupt-*")
+	if len(moved) != 1 {
+		t.Fatalf("expected the corrupt file to be moved aside, found %v", moved)
+	}
+	if data, _ := os.ReadFile(moved[0]); !bytes.Equal(data, corrupt) {
+		t.Errorf("the moved file should keep the original content, got %q", data)
+	}
+}
+
+func TestHistoryStore_ReadOnlyAfterReadError(t *testing.T) {
+	// A directory in place of the file cannot be read as history.
+	path := t.TempDir()
+
+	store, err := NewHistoryStore(path)
+	if err == nil {
+		t.Fatal("NewHistoryStore should report the read error")
+	}
+	if _, err := store.Add(HistoryRecord{FileName: "b.xlsx"}); !errors.Is(err, ErrHistoryReadOnly) {
+		t.Errorf("Add error = %v, want ErrHistoryReadOnly", err)
+	}
+}
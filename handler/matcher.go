@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	MatchCaseFold   = "casefold"
+	MatchWhitespace = "whitespace"
+	MatchHomoglyph  = "homoglyph"
+	MatchFuzzy      = "fuzzy"
+
+	DefaultFuzzyDistance = 2
+)
+
+// DefaultMatchModes tolerate the usual typing variations of the target text
+// without accepting different words.
+var DefaultMatchModes = []string{MatchCaseFold, MatchWhitespace, MatchHomoglyph}
+
+// latinLookalikes maps Latin letters to the Cyrillic letters they imitate.
+// Matching folds into Cyrillic because the target texts are Cyrillic.
+var latinLookalikes = func() map[rune]rune {
+	lookalikes := make(map[rune]rune, len(cyrillicLookalikes))
+	for cyrillic, latin := range cyrillicLookalikes {
+		lookalikes[latin] = cyrillic
+	}
+	return lookalikes
+}()
+
+// Matcher decides whether a cell contains the target text. Without modes it
+// is a plain substring search; each mode normalizes both sides before the
+// comparison, and fuzzy mode accepts up to maxDistance edits.
+type Matcher struct {
+	target      []rune
+	caseFold    bool
+	whitespace  bool
+	homoglyph   bool
+	fuzzy       bool
+	maxDistance int
+}
+
+func NewMatcher(target string, modes []string, maxDistance int) (*Matcher, error) {
+	m := &Matcher{maxDistance: maxDistance}
+
+	for _, mode := range modes {
+		switch strings.ToLower(mode) {
+		case MatchCaseFold:
+			m.caseFold = true
+		case MatchWhitespace:
+			m.whitespace = true
+		case MatchHomoglyph:
+			m.homoglyph = true
+		case MatchFuzzy:
+			m.fuzzy = true
+		default:
+			return nil, fmt.Errorf("unknown match mode %q", mode)
+		}
+	}
+
+	if m.fuzzy && m.maxDistance <= 0 {
+		m.maxDistance = DefaultFuzzyDistance
+	}
+
+	m.target = []rune(m.normalize(target))
+	return m, nil
+}
+
+func (m *Matcher) normalize(text string) string {
+	if m.whitespace {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	if m.homoglyph {
+		text = strings.Map(func(r rune) rune {
+			if cyrillic, ok := latinLookalikes[r]; ok {
+				return cyrillic
+			}
+			return r
+		}, text)
+	}
+	if m.caseFold {
+		text = strings.ToLower(text)
+	}
+	return text
+}
+
+func (m *Matcher) Match(cell string) bool {
+	if cell == "" {
+		return false
+	}
+
+	normalized := m.normalize(cell)
+	if strings.Contains(normalized, string(m.target)) {
+		return true
+	}
+	if !m.fuzzy {
+		return false
+	}
+
+	return withinEditDistance(m.target, []rune(normalized), m.maxDistance)
+}
+
+// withinEditDistance reports whether pattern occurs somewhere in text with at
+// most maxDistance insertions, deletions or substitutions. It is the
+// Levenshtein table with a free starting position in text.
+func withinEditDistance(pattern, text []rune, maxDistance int) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if len(text) < len(pattern)-maxDistance {
+		return false
+	}
+
+	previous := make([]int, len(text)+1)
+	current := make([]int, len(text)+1)
+
+	for i := 1; i <= len(pattern); i++ {
+		current[0] = i
+		for j := 1; j <= len(text); j++ {
+			cost := 1
+			if pattern[i-1] == text[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j-1]+cost, previous[j]+1, current[j-1]+1)
+		}
+		previous, current = current, previous
+	}
+
+	for _, distance := range previous {
+		if distance <= maxDistance {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package handler
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		name  string
+		modes []string
+		cell  string
+		want  bool
+	}{
+		{"exact", nil, "ТОВ ББС ІНШУРАНС", true},
+		{"exact is case-sensitive", nil, "ББС Іншуранс", false},
+		{"case-folded", []string{MatchCaseFold}, "ББС Іншуранс", true},
+		{"double spaces", []string{MatchWhitespace}, "ББС  ІНШУРАНС", true},
+		{"non-breaking space", []string{MatchWhitespace}, "ББС\u00a0ІНШУРАНС", true},
+		{"latin I without homoglyph mode", []string{MatchCaseFold}, "ББС INШУРАНС", false},
+		{"latin lookalikes", []string{MatchHomoglyph}, "ББC IHШУPAHC", true},
+		{"default modes", DefaultMatchModes, "ббс  iншуранс", true},
+		{"typo needs fuzzy", DefaultMatchModes, "ББС ІНШУРАНЧ", false},
+		{"fuzzy typo", []string{MatchFuzzy}, "ББС ІНШУРАНЧ", true},
+		{"fuzzy missing letter", []string{MatchFuzzy}, "ББС ІНШРАНС", true},
+		{"fuzzy too far", []string{MatchFuzzy}, "ББС СТРАХУВАННЯ", false},
+		{"empty cell", []string{MatchFuzzy}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := NewMatcher(DefaultTargetText, tt.modes, 0)
+			if err != nil {
+				t.Fatalf("NewMatcher failed: %v", err)
+			}
+			if got := matcher.Match(tt.cell); got != tt.want {
+				t.Errorf("Match(%q) with %v = %v, want %v", tt.cell, tt.modes, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewMatcher(DefaultTargetText, []string{"soundex"}, 0); err == nil {
+		t.Error("Unknown modes should be rejected")
+	}
+}
+
+func TestWithinEditDistance(t *testing.T) {
+	if !withinEditDistance([]rune("abc"), []rune("xxabdxx"), 1) {
+		t.Error("abd is one substitution away from abc")
+	}
+	if withinEditDistance([]rune("abc"), []rune("xxadexx"), 1) {
+		t.Error("ade is two edits away from abc")
+	}
+	if withinEditDistance([]rune("abcdef"), []rune("ab"), 2) {
+		t.Error("Text shorter than the pattern minus the distance cannot match")
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// mediaGroupCollector gathers the documents of a Telegram media group.
+// Telegram delivers files selected together as separate messages sharing a
+// media_group_id, with no marker for the last one, so a group is flushed
+// once no further file has arrived for the configured window.
+type mediaGroupCollector struct {
+	mu      sync.Mutex
+	window  time.Duration
+	batches map[string]*mediaGroupBatch
+	flush   func(bot *tgbotapi.BotAPI, uploads []HistoryRecord)
+}
+
+type mediaGroupBatch struct {
+	bot     *tgbotapi.BotAPI
+	uploads []HistoryRecord
+	timer   *time.Timer
+}
+
+func newMediaGroupCollector(window time.Duration, flush func(*tgbotapi.BotAPI, []HistoryRecord)) *mediaGroupCollector {
+	return &mediaGroupCollector{
+		window:  window,
+		batches: make(map[string]*mediaGroupBatch),
+		flush:   flush,
+	}
+}
+
+// Add queues an upload of the group and restarts the group's window. It
+// reports whether the upload started a new group.
+func (c *mediaGroupCollector) Add(bot *tgbotapi.BotAPI, groupID string, upload HistoryRecord) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if batch, found := c.batches[groupID]; found {
+		batch.uploads = append(batch.uploads, upload)
+		batch.timer.Reset(c.window)
+		return false
+	}
+
+	batch := &mediaGroupBatch{bot: bot, uploads: []HistoryRecord{upload}}
+	batch.timer = time.AfterFunc(c.window, func() { c.flushGroup(groupID) })
+	c.batches[groupID] = batch
+	return true
+}
+
+// flushGroup runs on the timer's goroutine. A timer reset after it already
+// fired may call it again for a group that is gone, which is a no-op.
+func (c *mediaGroupCollector) flushGroup(groupID string) {
+	c.mu.Lock()
+	batch, found := c.batches[groupID]
+	delete(c.batches, groupID)
+	c.mu.Unlock()
+
+	if !found {
+		return
+	}
+	c.flush(batch.bot, batch.uploads)
+}
+
+func (h *Handler) collectMediaGroup(bot *tgbotapi.BotAPI, groupID string, upload HistoryRecord) {
+	if h.mediaGroups.Add(bot, groupID, upload) {
+		msg := tgbotapi.NewMessage(upload.ChatID, TextMediaGroupCollecting)
+		bot.Send(msg)
+	}
+}
+
+// processMediaGroup reads the files of a media group and sends one merged,
+// de-duplicated output. Telegram attaches the caption to the first file, so
+// its rule and format apply to the whole group. It runs outside the update
+// loop and therefore only uses the parts of the handler that are safe for
+// concurrent use: the configuration, rules, history and the file system.
+func (h *Handler) processMediaGroup(bot *tgbotapi.BotAPI, uploads []HistoryRecord) {
+	first := uploads[0]
+	chatID := first.ChatID
+
+	writer := h.batchWriter(first.Template)
+	rule := h.uploadRule(first)
+
+	log.Printf("Processing media group of %d files from chat %d", len(uploads), chatID)
+
+	entries := make([]batchEntry, len(uploads))
+	for i, upload := range uploads {
+		entries[i] = batchEntry{Name: upload.FileName, Path: upload.FilePath}
+	}
+
+	h.readBatchEntries(entries, rule)
+	result := combineBatchResults("", rule, entries)
+	summary := formatBatchSummary(fmt.Sprintf(TextMediaGroupHeader, len(entries)), entries, result)
+
+	if _, _, ok := h.sendExtraction(bot, chatID, first.FilePath, summary, result, writer); !ok {
+		return
+	}
+
+	// Every file is recorded on its own so it can be rerun separately. The
+	// merged output is not attached to the records, since it must not be
+	// offered as the cached result of a single file.
+	for i, upload := range uploads {
+		upload.Template = writer.Name()
+		upload.Rule = rule.Name
+		upload.Targets = first.Targets
+		upload.Duplicates, upload.Order = first.Duplicates, first.Order
+		if entries[i].Result != nil {
+			upload.ResultCount = len(entries[i].Result.Matches)
+		}
+		h.recordHistory(upload)
+	}
+}
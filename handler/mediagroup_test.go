@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMediaGroupCollector(t *testing.T) {
+	flushed := make(chan []HistoryRecord, 2)
+	collector := newMediaGroupCollector(50*time.Millisecond, func(_ *tgbotapi.BotAPI, uploads []HistoryRecord) {
+		flushed <- uploads
+	})
+
+	if !collector.Add(nil, "group-1", HistoryRecord{FileName: "a.xlsx"}) {
+		t.Error("First file should start a new group")
+	}
+	if collector.Add(nil, "group-1", HistoryRecord{FileName: "b.xlsx"}) {
+		t.Error("Second file should join the existing group")
+	}
+	collector.Add(nil, "group-2", HistoryRecord{FileName: "c.xlsx"})
+
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case uploads := <-flushed:
+			got[uploads[0].FileName] = len(uploads)
+		case <-time.After(2 * time.Second):
+			t.Fatal("media group was not flushed")
+		}
+	}
+
+	if got["a.xlsx"] != 2 || got["c.xlsx"] != 1 {
+		t.Errorf("unexpected groups: %v", got)
+	}
+
+	select {
+	case uploads := <-flushed:
+		t.Errorf("unexpected extra flush: %+v", uploads)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const DefaultContractPattern = `^[0-9A-Za-z]+-[0-9A-Za-z]+$`
+
+var (
+	floatIntegerPattern     = regexp.MustCompile(`^([0-9]+)\.0+$`)
+	scientificNumberPattern = regexp.MustCompile(`^[0-9](\.[0-9]+)?[eE]\+?[0-9]+$`)
+)
+
+// cyrillicLookalikes maps Cyrillic letters to the Latin letters they are
+// usually mistaken for when contract numbers are typed by hand.
+var cyrillicLookalikes = map[rune]rune{
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'І': 'I', 'Ј': 'J', 'Ѕ': 'S',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ѕ': 's',
+}
+
+// normalizeContractValue cleans a raw cell value taken as part of a contract
+// number: it applies NFKC normalization, drops whitespace, invisible
+// characters and the leading apostrophe Excel uses for text cells, folds
+// Cyrillic lookalikes into Latin and repairs integers that were stored as
+// floats, such as "228960453.0" or "2.28960453E+08".
+func normalizeContractValue(raw string) string {
+	value := norm.NFKC.String(raw)
+
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, value)
+
+	value = strings.TrimLeft(value, "'")
+	value = foldHomoglyphs(value)
+
+	return repairFloatInteger(value)
+}
+
+// foldHomoglyphs replaces Cyrillic lookalikes only when every Cyrillic letter
+// in the value has a Latin counterpart, so genuinely Cyrillic text is kept.
+func foldHomoglyphs(value string) string {
+	for _, r := range value {
+		if _, ok := cyrillicLookalikes[r]; !ok && unicode.Is(unicode.Cyrillic, r) {
+			return value
+		}
+	}
+
+	return strings.Map(func(r rune) rune {
+		if latin, ok := cyrillicLookalikes[r]; ok {
+			return latin
+		}
+		return r
+	}, value)
+}
+
+func repairFloatInteger(value string) string {
+	if match := floatIntegerPattern.FindStringSubmatch(value); match != nil {
+		return match[1]
+	}
+
+	if scientificNumberPattern.MatchString(value) {
+		number, err := strconv.ParseFloat(value, 64)
+		if err == nil && number == math.Trunc(number) && number < 1e15 {
+			return strconv.FormatFloat(number, 'f', 0, 64)
+		}
+	}
+
+	return value
+}
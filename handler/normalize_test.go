@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestNormalizeContractValue(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"plain", "228960453", "228960453"},
+		{"surrounding spaces", "  228960453 ", "228960453"},
+		{"non-breaking spaces", "\u00a0228\u00a0960\u00a0453", "228960453"},
+		{"zero-width space", "2289\u200b60453", "228960453"},
+		{"leading apostrophe", "'0123", "0123"},
+		{"float from numeric cell", "228960453.0", "228960453"},
+		{"scientific notation", "2.28960453E+08", "228960453"},
+		{"fractional number kept", "12.5", "12.5"},
+		{"fullwidth digits", "\uff11\uff12\uff13", "123"},
+		{"cyrillic lookalikes", "АВ123", "AB123"},
+		{"mixed lookalikes", "EР-1", "EP-1"},
+		{"cyrillic text kept", "тест", "тест"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeContractValue(tt.raw); got != tt.want {
+				t.Errorf("normalizeContractValue(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractXlsx_NormalizesAndValidates(t *testing.T) {
+	h := NewHandlerWithConfig(Config{ContractPattern: `^[0-9]+-[0-9]+$`})
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetCellValue("Sheet1", "A1", "ББС ІНШУРАНС")
+	f.SetCellValue("Sheet1", "B1", " 228960453.0")
+	f.SetCellValue("Sheet1", "C1", "'123 ")
+	f.SetCellValue("Sheet1", "A2", "ББС ІНШУРАНС")
+	f.SetCellValue("Sheet1", "B2", "n/a")
+	f.SetCellValue("Sheet1", "C2", "456")
+
+	testFile := filepath.Join(t.TempDir(), "normalize.xlsx")
+	if err := f.SaveAs(testFile); err != nil {
+		t.Fatalf("Failed to save test file: %v", err)
+	}
+
+	result, err := h.readExcelFile(testFile, h.defaultRule())
+	if err != nil {
+		t.Fatalf("readExcelFile failed: %v", err)
+	}
+
+	if len(result.Matches) != 1 || result.Matches[0].Contract() != "228960453-123" {
+		t.Fatalf("Expected the normalized contract 228960453-123, got %v", result.Contracts())
+	}
+
+	if len(result.Skipped) != 1 {
+		t.Fatalf("Expected 1 skipped row, got %d", len(result.Skipped))
+	}
+	if skipped := result.Skipped[0]; skipped.Row != 2 || !strings.HasPrefix(skipped.Reason, SkipReasonInvalidNumber) {
+		t.Errorf("Unexpected skipped row: %+v", skipped)
+	}
+	if result.Sheets[0].Skipped != 1 {
+		t.Errorf("Sheet stats should count the invalid row, got %+v", result.Sheets[0])
+	}
+}
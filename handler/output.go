@@ -0,0 +1,550 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	FormatSQL  = "sql"
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatXLSX = "xlsx"
+	FormatList = "list"
+
+	// FormatSQLTempTable is the SQL script loading the numbers into a
+	// temporary table, which SQL Server handles better for long lists.
+	FormatSQLTempTable = "sqltemp"
+)
+
+// SQL script styles: a SELECT over an inline VALUES derived table, or a
+// #contracts temporary table filled with batched INSERTs.
+const (
+	SQLStyleInline    = "inline"
+	SQLStyleTempTable = "temp_table"
+)
+
+// sqlInsertBatchSize is the row limit of one INSERT ... VALUES in SQL Server.
+const sqlInsertBatchSize = 1000
+
+// OutputWriter renders an extraction result into the file sent back to the
+// user. Telegram derives the document MIME type from the file name, so
+// FileName must carry the extension that matches the content.
+type OutputWriter interface {
+	Name() string
+	FileName() string
+	Write(w io.Writer, result *ExtractionResult) error
+}
+
+var outputWriters = []OutputWriter{
+	SQLWriter{},
+	SQLWriter{Style: SQLStyleTempTable},
+	CSVWriter{},
+	JSONWriter{},
+	XLSXWriter{},
+	ListWriter{},
+	AnnotatedWriter{},
+}
+
+// availableWriters lists the built-in writers plus those that depend on
+// optional configuration, such as running the query against a database.
+func (h *Handler) availableWriters() []OutputWriter {
+	if h.queryRunner == nil {
+		return outputWriters
+	}
+
+	writers := append([]OutputWriter{}, outputWriters...)
+	return append(writers, QueryResultWriter{runner: h.queryRunner})
+}
+
+func (h *Handler) outputWriterFor(name string) (OutputWriter, bool) {
+	for _, writer := range h.availableWriters() {
+		if writer.Name() == strings.ToLower(name) {
+			return writer, true
+		}
+	}
+	return nil, false
+}
+
+func (h *Handler) outputFormatNames() []string {
+	writers := h.availableWriters()
+	names := make([]string, 0, len(writers))
+	for _, writer := range writers {
+		names = append(names, writer.Name())
+	}
+	return names
+}
+
+func renderOutput(writer OutputWriter, result *ExtractionResult) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writer.Write(&buf, result); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SQLWriter renders the contracts as a SELECT over an inline VALUES list, or
+// over a temporary table with the temp table style, that keeps the result
+// order in sort_seq: the file order, or the contract numbers when the rule
+// sorts by number. Matches of several targets are written as one script per
+// target, or as one script with a target column.
+type SQLWriter struct {
+	// Style is SQLStyleInline (or empty) or SQLStyleTempTable.
+	Style string
+}
+
+func (sw SQLWriter) Name() string {
+	if sw.Style == SQLStyleTempTable {
+		return FormatSQLTempTable
+	}
+	return FormatSQL
+}
+
+func (SQLWriter) FileName() string { return "script.txt" }
+
+func (sw SQLWriter) Write(w io.Writer, result *ExtractionResult) error {
+	if !result.separateTargets() {
+		_, err := io.WriteString(w, renderSQLScript(sw.Style, result.Numbers(), result.sqlColumns()...))
+		return err
+	}
+
+	for i, group := range result.targetGroups() {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n\n"); err != nil {
+				return err
+			}
+		}
+		script := renderSQLScript(sw.Style, group.Numbers(), matchColumns(result.Rule, group.Matches, false)...)
+		if _, err := io.WriteString(w, sqlComment(group.Target)+script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSVWriter lists one contract per row with its location in the workbook.
+// The UTF-8 byte order mark makes Excel open Cyrillic sheet names correctly.
+type CSVWriter struct{}
+
+func (CSVWriter) Name() string     { return FormatCSV }
+func (CSVWriter) FileName() string { return "contracts.csv" }
+
+func (CSVWriter) Write(w io.Writer, result *ExtractionResult) error {
+	if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(outputColumns(result)); err != nil {
+		return err
+	}
+
+	for _, match := range result.Matches {
+		if err := csvWriter.Write(outputRow(result, match)); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+type JSONWriter struct{}
+
+func (JSONWriter) Name() string     { return FormatJSON }
+func (JSONWriter) FileName() string { return "contracts.json" }
+
+type jsonContract struct {
+	Number string   `json:"number"`
+	Sheet  string   `json:"sheet"`
+	Row    int      `json:"row"`
+	Cell   string   `json:"cell"`
+	Values []string `json:"values"`
+	Target string   `json:"target,omitempty"`
+	// Columns are the rule's additional columns by name.
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+type jsonSkippedRow struct {
+	Sheet  string `json:"sheet"`
+	Row    int    `json:"row"`
+	Cell   string `json:"cell"`
+	Reason string `json:"reason"`
+}
+
+type jsonOutput struct {
+	Target    string           `json:"target"`
+	Count     int              `json:"count"`
+	Contracts []jsonContract   `json:"contracts"`
+	Skipped   []jsonSkippedRow `json:"skipped"`
+	Warnings  []string         `json:"warnings"`
+}
+
+func (JSONWriter) Write(w io.Writer, result *ExtractionResult) error {
+	output := jsonOutput{
+		Target:    result.TargetText,
+		Count:     len(result.Matches),
+		Contracts: make([]jsonContract, 0, len(result.Matches)),
+		Skipped:   make([]jsonSkippedRow, 0, len(result.Skipped)),
+		Warnings:  append([]string{}, result.Warnings...),
+	}
+
+	for _, match := range result.Matches {
+		contract := jsonContract{
+			Number: match.Number(),
+			Sheet:  match.Sheet,
+			Row:    match.Row,
+			Cell:   match.Cell,
+			Values: match.Values,
+		}
+		if result.multiTarget() {
+			contract.Target = match.Target
+		}
+		if len(result.Rule.Columns) > 0 {
+			contract.Columns = make(map[string]string, len(result.Rule.Columns))
+			for _, column := range result.Rule.Columns {
+				contract.Columns[column.Name] = match.Column(column.Name)
+			}
+		}
+		output.Contracts = append(output.Contracts, contract)
+	}
+
+	for _, skipped := range result.Skipped {
+		output.Skipped = append(output.Skipped, jsonSkippedRow(skipped))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+type XLSXWriter struct{}
+
+func (XLSXWriter) Name() string     { return FormatXLSX }
+func (XLSXWriter) FileName() string { return "contracts.xlsx" }
+
+func (XLSXWriter) Write(w io.Writer, result *ExtractionResult) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheetName := "Contracts"
+	if err := f.SetSheetName("Sheet1", sheetName); err != nil {
+		return fmt.Errorf("failed to name sheet: %w", err)
+	}
+
+	columns := outputColumns(result)
+	if err := f.SetSheetRow(sheetName, "A1", &columns); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	for i, match := range result.Matches {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		row := outputRow(result, match)
+		if err := f.SetSheetRow(sheetName, cell, &row); err != nil {
+			return fmt.Errorf("failed to write row %d: %w", i+2, err)
+		}
+	}
+
+	if err := f.SetColWidth(sheetName, "A", "A", 24); err != nil {
+		return fmt.Errorf("failed to set column width: %w", err)
+	}
+
+	return f.Write(w)
+}
+
+// ListWriter emits bare contract numbers, one per line. With separate
+// target output every target starts with a "# <target>" line.
+type ListWriter struct{}
+
+func (ListWriter) Name() string     { return FormatList }
+func (ListWriter) FileName() string { return "contracts.txt" }
+
+func (ListWriter) Write(w io.Writer, result *ExtractionResult) error {
+	if !result.separateTargets() {
+		return writeNumberList(w, result.Numbers())
+	}
+
+	for i, group := range result.targetGroups() {
+		header := "# " + group.Target + "\n"
+		if i > 0 {
+			header = "\n" + header
+		}
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		if err := writeNumberList(w, group.Numbers()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeNumberList(w io.Writer, numbers []string) error {
+	for _, number := range numbers {
+		if _, err := io.WriteString(w, number+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputColumns are the columns of the tabular formats; a target column is
+// added when the rule searched for several targets, followed by the rule's
+// additional columns.
+func outputColumns(result *ExtractionResult) []string {
+	columns := []string{"number", "sheet", "row", "cell"}
+	if result.multiTarget() {
+		columns = append(columns, "target")
+	}
+	for _, column := range result.Rule.Columns {
+		columns = append(columns, column.Name)
+	}
+	return columns
+}
+
+func outputRow(result *ExtractionResult, match ContractMatch) []string {
+	row := []string{match.Number(), match.Sheet, strconv.Itoa(match.Row), match.Cell}
+	if result.multiTarget() {
+		row = append(row, match.Target)
+	}
+	for _, column := range result.Rule.Columns {
+		row = append(row, match.Column(column.Name))
+	}
+	return row
+}
+
+// renderSQLScript builds the lookup script for full contract numbers in one
+// of the SQL styles; anything but the temp table style is inline.
+func renderSQLScript(style string, numbers []string, columns ...sqlColumn) string {
+	if style == SQLStyleTempTable {
+		return buildTempTableScript(numbers, sqlInsertBatchSize, columns...)
+	}
+	return buildSQLScript(numbers, columns...)
+}
+
+// sqlColumn is an additional column of the VALUES list, holding one value
+// of Type per contract number and selected under Label.
+type sqlColumn struct {
+	Name   string
+	Label  string
+	Type   string
+	Values []string
+}
+
+// sqlColumns are the additional VALUES columns of a result: the target of
+// every match when the rule searched for several, then the rule's columns.
+func (r *ExtractionResult) sqlColumns() []sqlColumn {
+	return matchColumns(r.Rule, r.Matches, r.multiTarget())
+}
+
+func matchColumns(rule Rule, matches []ContractMatch, withTarget bool) []sqlColumn {
+	var columns []sqlColumn
+	if withTarget {
+		targets := make([]string, len(matches))
+		for i, match := range matches {
+			targets[i] = match.Target
+		}
+		columns = append(columns, sqlColumn{Name: "target", Label: "Ціль пошуку", Type: ColumnTypeText, Values: targets})
+	}
+
+	for _, column := range rule.Columns {
+		values := make([]string, len(matches))
+		for i, match := range matches {
+			values[i] = match.Column(column.Name)
+		}
+		columns = append(columns, sqlColumn{Name: column.Name, Label: column.Name, Type: column.Type, Values: values})
+	}
+
+	return columns
+}
+
+// sqlQuote renders text as a string literal. Contract numbers come from
+// cells the uploader controls, so every value goes through it.
+func sqlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// sqlLiteral renders a value of an additional column by its type. Missing
+// values become NULL; text is written as Unicode so names in Cyrillic
+// survive any collation.
+func sqlLiteral(columnType, value string) string {
+	if value == "" {
+		return "NULL"
+	}
+
+	switch columnType {
+	case ColumnTypeNumber:
+		return value
+	case ColumnTypeDate:
+		return "CAST(" + sqlQuote(value) + " AS date)"
+	}
+	return "N" + sqlQuote(value)
+}
+
+// sqlComment renders text as a line comment heading a script.
+func sqlComment(text string) string {
+	return "-- " + strings.Join(strings.Fields(text), " ") + "\n"
+}
+
+// buildSQLScript builds the lookup script for full contract numbers, keeping
+// their order in the result. columns are selected after the number.
+func buildSQLScript(numbers []string, columns ...sqlColumn) string {
+	var sql strings.Builder
+
+	writeSQLSelect(&sql, columns)
+	sql.WriteString("FROM \n")
+	sql.WriteString("    (VALUES \n")
+	writeSQLValues(&sql, numbers, columns)
+	sql.WriteString(fmt.Sprintf("\n    ) AS sort_order(%s)\n", strings.Join(sqlColumnNames(columns), ", ")))
+	writeSQLJoins(&sql)
+
+	return sql.String()
+}
+
+// buildTempTableScript builds the same lookup as buildSQLScript, but loads
+// the numbers into a #contracts temporary table first. The number column is
+// copied from contract.number, so it has the same type and length, and it
+// and the text columns take the database's collation instead of tempdb's;
+// otherwise the join fails on a server whose collation differs. SQL Server
+// accepts at most 1000 rows per INSERT, so the rows are inserted in batches
+// of batchSize. The table is dropped at the end.
+func buildTempTableScript(numbers []string, batchSize int, columns ...sqlColumn) string {
+	var sql strings.Builder
+	names := strings.Join(sqlColumnNames(columns), ", ")
+
+	sql.WriteString("IF OBJECT_ID('tempdb..#contracts') IS NOT NULL DROP TABLE #contracts;\n\n")
+	sql.WriteString("SELECT TOP 0\n")
+	sql.WriteString("    c.number COLLATE DATABASE_DEFAULT AS number,\n")
+	sql.WriteString("    ISNULL(CAST(0 AS int), 0) AS sort_seq\n")
+	sql.WriteString("INTO #contracts\n")
+	sql.WriteString("FROM contract c;\n\n")
+	sql.WriteString("ALTER TABLE #contracts ADD\n")
+	sql.WriteString("    PRIMARY KEY (sort_seq)")
+	for _, column := range columns {
+		sql.WriteString(fmt.Sprintf(",\n    %s %s NULL", sqlIdentifier(column.Name), sqlColumnType(column.Type)))
+	}
+	sql.WriteString(";\n\n")
+
+	for start := 0; start < len(numbers); start += batchSize {
+		end := min(start+batchSize, len(numbers))
+		batch := make([]sqlColumn, len(columns))
+		for i, column := range columns {
+			batch[i] = column
+			batch[i].Values = column.Values[start:end]
+		}
+
+		sql.WriteString(fmt.Sprintf("INSERT INTO #contracts (%s) VALUES \n", names))
+		writeSQLValuesFrom(&sql, numbers[start:end], start, batch)
+		sql.WriteString(";\n\n")
+	}
+
+	writeSQLSelect(&sql, columns)
+	sql.WriteString("FROM \n")
+	sql.WriteString("    #contracts sort_order\n")
+	writeSQLJoins(&sql)
+	sql.WriteString("\n\nDROP TABLE #contracts;")
+
+	return sql.String()
+}
+
+// writeSQLSelect writes the SELECT list shared by both script styles.
+func writeSQLSelect(sql *strings.Builder, columns []sqlColumn) {
+	sql.WriteString("SELECT \n")
+	sql.WriteString("    sort_order.number AS 'Номер договору',\n")
+	for _, column := range columns {
+		sql.WriteString(fmt.Sprintf("    sort_order.%s AS %s,\n", sqlIdentifier(column.Name), sqlQuote(column.Label)))
+	}
+	sql.WriteString("    dbo.getCagentFullName(c.id_acquisitor) AS 'Аквізитор',\n")
+	sql.WriteString("    dbo.getCagentFullName(c.id_responsible) AS 'Відповідальна особа',\n")
+	sql.WriteString("    (\n")
+	sql.WriteString("        SELECT \n")
+	sql.WriteString("            CASE \n")
+	sql.WriteString("                WHEN sch.id_parent IS NULL THEN ISNULL(sch.name, '') \n")
+	sql.WriteString("                ELSE ISNULL(\n")
+	sql.WriteString("                    (SELECT csch.name FROM sale_channel csch WHERE csch.id = sch.id_parent), \n")
+	sql.WriteString("                    ''\n")
+	sql.WriteString("                ) \n")
+	sql.WriteString("            END \n")
+	sql.WriteString("        FROM sale_channel sch \n")
+	sql.WriteString("        WHERE sch.id = c.id_saleChannel\n")
+	sql.WriteString("    ) AS 'Канал продажів',\n")
+	sql.WriteString("    (\n")
+	sql.WriteString("        SELECT \n")
+	sql.WriteString("            CASE \n")
+	sql.WriteString("                WHEN sch.id_parent IS NULL THEN '' \n")
+	sql.WriteString("                ELSE ISNULL(sch.name, '') \n")
+	sql.WriteString("            END \n")
+	sql.WriteString("        FROM sale_channel sch \n")
+	sql.WriteString("        WHERE sch.id = c.id_saleChannel\n")
+	sql.WriteString("    ) AS 'Підканал продажів',\n")
+	sql.WriteString("    ISNULL(div.name, '') AS 'Обліковий підрозділ',\n")
+	sql.WriteString("    (\n")
+	sql.WriteString("        SELECT \n")
+	sql.WriteString("            CASE \n")
+	sql.WriteString("                WHEN h_div.id_parent IS NULL THEN '' \n")
+	sql.WriteString("                ELSE ISNULL(p_div.name, '') \n")
+	sql.WriteString("            END \n")
+	sql.WriteString("        FROM division p_div \n")
+	sql.WriteString("        WHERE p_div.id = h_div.id_parent\n")
+	sql.WriteString("    ) AS 'Вищестоящий підрозділ'\n")
+}
+
+func writeSQLValues(sql *strings.Builder, numbers []string, columns []sqlColumn) {
+	writeSQLValuesFrom(sql, numbers, 0, columns)
+}
+
+// writeSQLValuesFrom writes one VALUES row per number; sort_seq starts at
+// first.
+func writeSQLValuesFrom(sql *strings.Builder, numbers []string, first int, columns []sqlColumn) {
+	for index, number := range numbers {
+		if index > 0 {
+			sql.WriteString(",\n")
+		}
+		sql.WriteString(fmt.Sprintf("        (%s, %d", sqlQuote(number), first+index))
+		for _, column := range columns {
+			sql.WriteString(", " + sqlLiteral(column.Type, column.Values[index]))
+		}
+		sql.WriteString(")")
+	}
+}
+
+func writeSQLJoins(sql *strings.Builder) {
+	sql.WriteString("LEFT JOIN contract c ON c.number = sort_order.number\n")
+	sql.WriteString("LEFT JOIN division div ON div.id = c.id_division\n")
+	sql.WriteString("LEFT JOIN helement h_div ON h_div.id = div.id\n")
+	sql.WriteString("ORDER BY \n")
+	sql.WriteString("    sort_order.sort_seq;")
+}
+
+func sqlColumnNames(columns []sqlColumn) []string {
+	names := []string{"number", "sort_seq"}
+	for _, column := range columns {
+		names = append(names, sqlIdentifier(column.Name))
+	}
+	return names
+}
+
+// sqlIdentifier brackets a column name, so names that are reserved words,
+// such as order or user, still work as identifiers.
+func sqlIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// sqlColumnType is the temporary table type of an additional column; text
+// takes the database's collation, like the number column.
+func sqlColumnType(columnType string) string {
+	switch columnType {
+	case ColumnTypeNumber:
+		return "decimal(38, 10)"
+	case ColumnTypeDate:
+		return "date"
+	}
+	return "nvarchar(400) COLLATE DATABASE_DEFAULT"
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func testExtractionResult() *ExtractionResult {
+	return &ExtractionResult{
+		TargetText: DefaultTargetText,
+		Matches: []ContractMatch{
+			{Sheet: "Реєстр", Row: 2, Cell: "A2", Values: []string{"228960453", "123"}},
+			{Sheet: "Реєстр", Row: 3, Cell: "A3", Values: []string{"228209382", "456"}},
+		},
+		Skipped: []SkippedRow{{Sheet: "Реєстр", Row: 4, Cell: "A4", Reason: SkipReasonMissingValues}},
+	}
+}
+
+func TestOutputWriterFor(t *testing.T) {
+	h := &Handler{}
+
+	for _, name := range []string{"sql", "csv", "json", "xlsx", "list", "CSV"} {
+		writer, ok := h.outputWriterFor(name)
+		if !ok {
+			t.Errorf("outputWriterFor(%q) should find a writer", name)
+			continue
+		}
+		if filepath.Ext(writer.FileName()) == "" {
+			t.Errorf("Writer %s should declare a file name and content type", writer.Name())
+		}
+	}
+
+	if _, ok := h.outputWriterFor("pdf"); ok {
+		t.Error("outputWriterFor(\"pdf\") should not find a writer")
+	}
+	if _, ok := h.outputWriterFor(FormatResults); ok {
+		t.Error("Results format should only be available with a database configured")
+	}
+}
+
+func TestOutputWriters(t *testing.T) {
+	result := testExtractionResult()
+
+	t.Run("csv", func(t *testing.T) {
+		content, err := renderOutput(CSVWriter{}, result)
+		if err != nil {
+			t.Fatalf("renderOutput failed: %v", err)
+		}
+		if !bytes.HasPrefix(content, []byte("\uFEFF")) {
+			t.Error("CSV should start with a UTF-8 byte order mark")
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected header and 2 rows, got %d lines", len(lines))
+		}
+		if lines[1] != "EP-228960453-123,Реєстр,2,A2" {
+			t.Errorf("Unexpected CSV row: %q", lines[1])
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		content, err := renderOutput(JSONWriter{}, result)
+		if err != nil {
+			t.Fatalf("renderOutput failed: %v", err)
+		}
+
+		var decoded jsonOutput
+		if err := json.Unmarshal(content, &decoded); err != nil {
+			t.Fatalf("Output is not valid JSON: %v", err)
+		}
+		if decoded.Count != 2 || decoded.Contracts[1].Number != "EP-228209382-456" {
+			t.Errorf("Unexpected JSON output: %+v", decoded)
+		}
+		if len(decoded.Skipped) != 1 || decoded.Warnings == nil {
+			t.Errorf("JSON should include skipped rows and an empty warnings list: %+v", decoded)
+		}
+	})
+
+	t.Run("xlsx", func(t *testing.T) {
+		content, err := renderOutput(XLSXWriter{}, result)
+		if err != nil {
+			t.Fatalf("renderOutput failed: %v", err)
+		}
+
+		f, err := excelize.OpenReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Output is not a valid workbook: %v", err)
+		}
+		defer f.Close()
+
+		value, _ := f.GetCellValue("Contracts", "A3")
+		if value != "EP-228209382-456" {
+			t.Errorf("A3 = %q, want %q", value, "EP-228209382-456")
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		content, err := renderOutput(ListWriter{}, result)
+		if err != nil {
+			t.Fatalf("renderOutput failed: %v", err)
+		}
+		if string(content) != "EP-228960453-123\nEP-228209382-456\n" {
+			t.Errorf("Unexpected list output: %q", content)
+		}
+	})
+}
+
+func TestResolveFormat(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HistoryPath = filepath.Join(t.TempDir(), "history.json")
+	cfg.PreferencesPath = filepath.Join(t.TempDir(), "preferences.json")
+	h := NewHandlerWithConfig(cfg)
+
+	if format := h.resolveFormat(1, ""); format != FormatSQL {
+		t.Errorf("Default format = %s, want %s", format, FormatSQL)
+	}
+
+	if err := h.preferences.Update(1, func(prefs *UserPreferences) { prefs.Format = FormatXLSX }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if format := h.resolveFormat(1, "monthly register"); format != FormatXLSX {
+		t.Errorf("Saved default format = %s, want %s", format, FormatXLSX)
+	}
+	if format := h.resolveFormat(1, "please send format=JSON"); format != FormatJSON {
+		t.Errorf("Caption format = %s, want %s", format, FormatJSON)
+	}
+	if format := h.resolveFormat(1, "csv"); format != FormatCSV {
+		t.Errorf("Caption with only a format = %s, want %s", format, FormatCSV)
+	}
+	if format := h.resolveFormat(1, "list of contracts, results for March"); format != FormatXLSX {
+		t.Errorf("Bare format words in a caption should be ignored, got %s", format)
+	}
+
+	reloaded, err := NewPreferencesStore(cfg.PreferencesPath)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if prefs := reloaded.Get(1); prefs.Format != FormatXLSX {
+		t.Errorf("Reloaded format = %q, want %q", prefs.Format, FormatXLSX)
+	}
+}
+
+func TestWelcomeTextListsOutputFormats(t *testing.T) {
+	h := NewHandler()
+
+	text := GetWelcomeText("Olena", h.outputFormatNames())
+	for _, name := range h.outputFormatNames() {
+		if !strings.Contains(text, name) {
+			t.Errorf("welcome text should list format %q:\n%s", name, text)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+type UserPreferences struct {
+	Format string `json:"format,omitempty"`
+}
+
+// PreferencesStore keeps per-user defaults in a JSON file keyed by user id.
+type PreferencesStore struct {
+	path  string
+	mu    sync.Mutex
+	prefs map[int64]UserPreferences
+}
+
+func NewPreferencesStore(path string) (*PreferencesStore, error) {
+	store := &PreferencesStore{
+		path:  path,
+		prefs: make(map[int64]UserPreferences),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return store, fmt.Errorf("failed to read preferences file: %w", err)
+	}
+
+	var raw map[string]UserPreferences
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return store, fmt.Errorf("failed to parse preferences file: %w", err)
+	}
+
+	for key, prefs := range raw {
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			continue
+		}
+		store.prefs[userID] = prefs
+	}
+
+	return store, nil
+}
+
+func (s *PreferencesStore) Get(userID int64) UserPreferences {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.prefs[userID]
+}
+
+func (s *PreferencesStore) Update(userID int64, update func(*UserPreferences)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.prefs[userID]
+	prefs := previous
+	update(&prefs)
+	s.prefs[userID] = prefs
+
+	if err := s.save(); err != nil {
+		if existed {
+			s.prefs[userID] = previous
+		} else {
+			delete(s.prefs, userID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *PreferencesStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create preferences directory: %w", err)
+	}
+
+	raw := make(map[string]UserPreferences, len(s.prefs))
+	for userID, prefs := range s.prefs {
+		raw[strconv.FormatInt(userID, 10)] = prefs
+	}
+
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode preferences: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write preferences file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace preferences file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreferencesStore_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "preferences.json")
+
+	store, err := NewPreferencesStore(path)
+	if err != nil {
+		t.Fatalf("NewPreferencesStore failed for a missing file: %v", err)
+	}
+	if got := store.Get(1).Format; got != "" {
+		t.Errorf("a new store should have no preferences, got format %q", got)
+	}
+
+	if err := store.Update(1, func(prefs *UserPreferences) { prefs.Format = FormatCSV }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := store.Update(2, func(prefs *UserPreferences) { prefs.Format = FormatJSON }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	reloaded, err := NewPreferencesStore(path)
+	if err != nil {
+		t.Fatalf("NewPreferencesStore failed: %v", err)
+	}
+	if got := reloaded.Get(1).Format; got != FormatCSV {
+		t.Errorf("user 1 format = %q, want %q", got, FormatCSV)
+	}
+	if got := reloaded.Get(2).Format; got != FormatJSON {
+		t.Errorf("user 2 format = %q, want %q", got, FormatJSON)
+	}
+}
+
+func TestPreferencesStore_CorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preferences.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	store, err := NewPreferencesStore(path)
+	if err == nil {
+		t.Error("a corrupt file should be reported")
+	}
+	if store == nil || store.Get(1).Format != "" {
+		t.Error("a corrupt file should still give an empty store")
+	}
+}
+
+func TestPreferencesStore_RollbackOnWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preferences.json")
+	store, err := NewPreferencesStore(path)
+	if err != nil {
+		t.Fatalf("NewPreferencesStore failed: %v", err)
+	}
+	if err := store.Update(1, func(prefs *UserPreferences) { prefs.Format = FormatCSV }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// A directory in place of the temporary file makes every write fail.
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatalf("Failed to block the temporary file: %v", err)
+	}
+
+	if err := store.Update(1, func(prefs *UserPreferences) { prefs.Format = FormatJSON }); err == nil {
+		t.Fatal("Update should fail when the file cannot be written")
+	}
+	if got := store.Get(1).Format; got != FormatCSV {
+		t.Errorf("a failed update should keep the previous format, got %q", got)
+	}
+
+	if err := store.Update(2, func(prefs *UserPreferences) { prefs.Format = FormatJSON }); err == nil {
+		t.Fatal("Update should fail when the file cannot be written")
+	}
+	if got := store.Get(2).Format; got != "" {
+		t.Errorf("a failed update should not add the user, got format %q", got)
+	}
+}
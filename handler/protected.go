@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxPasswordAttempts = 3
+
+var (
+	ErrWorkbookEncrypted = errors.New("workbook is password-protected")
+	ErrWrongPassword     = errors.New("workbook password is not correct")
+)
+
+// encryptedPackageName is the stream an encrypted OOXML workbook keeps its
+// content in, as it appears in the OLE2 directory (UTF-16LE).
+var encryptedPackageName = func() []byte {
+	var name bytes.Buffer
+	for _, unit := range utf16.Encode([]rune("EncryptedPackage")) {
+		name.WriteByte(byte(unit))
+		name.WriteByte(byte(unit >> 8))
+	}
+	return name.Bytes()
+}()
+
+// pendingPassword is an upload waiting for the user to send the workbook
+// password. The password itself is never stored.
+type pendingPassword struct {
+	upload   HistoryRecord
+	attempts int
+}
+
+// isEncryptedWorkbook reports whether an upload is an encrypted OOXML
+// package: an OLE2 container holding an EncryptedPackage stream instead of
+// the usual ZIP archive.
+func isEncryptedWorkbook(filePath string) (bool, error) {
+	kind, err := sniffWorkbook(filePath)
+	return kind == kindEncrypted, err
+}
+
+func (h *Handler) requestPassword(bot *tgbotapi.BotAPI, upload HistoryRecord) {
+	chatID := upload.ChatID
+
+	h.pendingPasswords[chatID] = pendingPassword{upload: upload}
+	h.setState(chatID, StateAwaitingPassword)
+	log.Printf("Workbook %s is password-protected, asking chat %d for the password", upload.FileName, chatID)
+
+	msg := tgbotapi.NewMessage(chatID, TextPasswordRequired)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+}
+
+// handlePasswordText takes the message as the password of the pending
+// upload. The message is deleted from the chat right away, and the password
+// only lives for the duration of this call.
+func (h *Handler) handlePasswordText(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatID := update.Message.Chat.ID
+	password := update.Message.Text
+
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(chatID, update.Message.MessageID)); err != nil {
+		log.Printf("Error deleting password message in chat %d: %v", chatID, err)
+	}
+
+	pending, found := h.pendingPasswords[chatID]
+	if !found {
+		h.setState(chatID, StateDefault)
+		return
+	}
+
+	err := h.processWorkbook(bot, pending.upload, password)
+	if !errors.Is(err, ErrWrongPassword) {
+		delete(h.pendingPasswords, chatID)
+		h.setState(chatID, StateDefault)
+		return
+	}
+
+	pending.attempts++
+	log.Printf("Wrong password for %s in chat %d (attempt %d/%d)", pending.upload.FileName, chatID, pending.attempts, maxPasswordAttempts)
+
+	if pending.attempts >= maxPasswordAttempts {
+		delete(h.pendingPasswords, chatID)
+		h.setState(chatID, StateDefault)
+		msg := tgbotapi.NewMessage(chatID, TextPasswordAttemptsExceeded)
+		bot.Send(msg)
+		return
+	}
+
+	h.pendingPasswords[chatID] = pending
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(TextPasswordIncorrect, maxPasswordAttempts-pending.attempts))
+	bot.Send(msg)
+}
+
+// readErrorText explains a failed read to the user.
+func readErrorText(err error) string {
+	switch {
+	case errors.Is(err, ErrWorkbookEncrypted):
+		return TextWorkbookEncrypted
+	case errors.Is(err, ErrUnsupportedFormat):
+		return TextFileBinaryUnsupported
+	default:
+		return TextFileReadError
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func writeEncryptedWorkbook(t *testing.T, password string) string {
+	t.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetCellValue("Sheet1", "A1", DefaultTargetText)
+	f.SetCellValue("Sheet1", "B1", "228960453")
+	f.SetCellValue("Sheet1", "C1", "123")
+
+	path := filepath.Join(t.TempDir(), "protected.xlsx")
+	if err := f.SaveAs(path, excelize.Options{Password: password}); err != nil {
+		t.Fatalf("failed to save encrypted workbook: %v", err)
+	}
+	return path
+}
+
+func TestIsEncryptedWorkbook(t *testing.T) {
+	encrypted := writeEncryptedWorkbook(t, "secret")
+
+	plain := filepath.Join(t.TempDir(), "plain.xlsx")
+	f := excelize.NewFile()
+	if err := f.SaveAs(plain); err != nil {
+		t.Fatalf("failed to save workbook: %v", err)
+	}
+	f.Close()
+
+	if got, err := isEncryptedWorkbook(encrypted); err != nil || !got {
+		t.Errorf("isEncryptedWorkbook(encrypted) = %v, %v; want true", got, err)
+	}
+	if got, err := isEncryptedWorkbook(plain); err != nil || got {
+		t.Errorf("isEncryptedWorkbook(plain) = %v, %v; want false", got, err)
+	}
+	if err := verifySpreadsheetSignature(encrypted, "protected.xlsx"); err != nil {
+		t.Errorf("signature of an encrypted workbook rejected: %v", err)
+	}
+}
+
+func TestReadXlsxFile_Password(t *testing.T) {
+	h := NewHandler()
+	path := writeEncryptedWorkbook(t, "secret")
+
+	result, err := h.readXlsxFile(path, h.defaultRule(), "secret")
+	if err != nil {
+		t.Fatalf("reading with the correct password failed: %v", err)
+	}
+	if len(result.Matches) != 1 || result.Matches[0].Number() != "EP-228960453-123" {
+		t.Errorf("unexpected matches: %+v", result.Matches)
+	}
+
+	if _, err := h.readXlsxFile(path, h.defaultRule(), ""); !errors.Is(err, ErrWorkbookEncrypted) {
+		t.Errorf("reading without a password: got %v, want ErrWorkbookEncrypted", err)
+	}
+	if _, err := h.readXlsxFile(path, h.defaultRule(), "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("reading with a wrong password: got %v, want ErrWrongPassword", err)
+	}
+}
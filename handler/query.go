@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const FormatResults = "results"
+
+var ErrQueryNotReadOnly = errors.New("query is not a read-only SELECT")
+
+var (
+	sqlLineComment  = regexp.MustCompile(`--[^\n]*`)
+	sqlBlockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlStringLit    = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlWriteKeyword = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE|DROP|ALTER|CREATE|TRUNCATE|EXEC|EXECUTE|GRANT|REVOKE|DENY|INTO|BACKUP|RESTORE|DBCC|SHUTDOWN)\b`)
+)
+
+type QueryResult struct {
+	Columns   []string
+	Rows      [][]any
+	Truncated bool
+}
+
+// QueryRunner executes generated scripts against the configured database.
+// Read-only access is enforced twice: the statement must be a single SELECT
+// without write keywords, and it runs in a transaction that is always rolled
+// back. A read-only database login is still recommended.
+type QueryRunner struct {
+	db      *sql.DB
+	timeout time.Duration
+	maxRows int
+}
+
+func NewQueryRunner(db *sql.DB, timeout time.Duration, maxRows int) *QueryRunner {
+	return &QueryRunner{
+		db:      db,
+		timeout: timeout,
+		maxRows: maxRows,
+	}
+}
+
+func (q *QueryRunner) Run(ctx context.Context, query string) (*QueryResult, error) {
+	if err := validateReadOnlyQuery(query); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w", err)
+	}
+
+	result := &QueryResult{Columns: columns}
+	for rows.Next() {
+		if len(result.Rows) == q.maxRows {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+
+		for i, value := range values {
+			if raw, ok := value.([]byte); ok {
+				values[i] = string(raw)
+			}
+		}
+
+		result.Rows = append(result.Rows, values)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return result, nil
+}
+
+// validateReadOnlyQuery accepts a single SELECT (or WITH ... SELECT)
+// statement. String literals are stripped first so that keywords inside
+// them, such as a contract named 'DROP', do not count. They must go before
+// anything else: a comment marker inside one literal could otherwise hide
+// the code between two literals. Comments are not expected in generated
+// scripts and are rejected, as is an unterminated literal.
+func validateReadOnlyQuery(query string) error {
+	stripped := sqlStringLit.ReplaceAllString(query, "''")
+	if strings.Contains(strings.ReplaceAll(stripped, "''", ""), "'") {
+		return fmt.Errorf("%w: unterminated string literal", ErrQueryNotReadOnly)
+	}
+	if sqlLineComment.MatchString(stripped) || sqlBlockComment.MatchString(stripped) || strings.Contains(stripped, "/*") {
+		return fmt.Errorf("%w: contains a comment", ErrQueryNotReadOnly)
+	}
+
+	stripped = strings.TrimSpace(stripped)
+	stripped = strings.TrimSpace(strings.TrimSuffix(stripped, ";"))
+
+	upper := strings.ToUpper(stripped)
+	if !strings.HasPrefix(upper, "SELECT") && !strings.HasPrefix(upper, "WITH") {
+		return fmt.Errorf("%w: must start with SELECT", ErrQueryNotReadOnly)
+	}
+
+	if strings.Contains(stripped, ";") {
+		return fmt.Errorf("%w: multiple statements", ErrQueryNotReadOnly)
+	}
+
+	if keyword := sqlWriteKeyword.FindString(stripped); keyword != "" {
+		return fmt.Errorf("%w: contains %s", ErrQueryNotReadOnly, strings.ToUpper(keyword))
+	}
+
+	return nil
+}
+
+// QueryResultWriter runs the generated SQL script and returns the result set
+// as a workbook instead of the script itself.
+type QueryResultWriter struct {
+	runner *QueryRunner
+}
+
+func (QueryResultWriter) Name() string     { return FormatResults }
+func (QueryResultWriter) FileName() string { return "results.xlsx" }
+
+func (qw QueryResultWriter) Write(w io.Writer, result *ExtractionResult) error {
+	queryResult, err := qw.runner.Run(context.Background(), buildSQLScript(result.Numbers(), result.sqlColumns()...))
+	if err != nil {
+		return err
+	}
+
+	return writeQueryResult(w, queryResult)
+}
+
+func writeQueryResult(w io.Writer, result *QueryResult) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheetName := "Results"
+	if err := f.SetSheetName("Sheet1", sheetName); err != nil {
+		return fmt.Errorf("failed to name sheet: %w", err)
+	}
+
+	if err := f.SetSheetRow(sheetName, "A1", &result.Columns); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	for i, row := range result.Rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := f.SetSheetRow(sheetName, cell, &row); err != nil {
+			return fmt.Errorf("failed to write row %d: %w", i+2, err)
+		}
+	}
+
+	if result.Truncated {
+		cell, _ := excelize.CoordinatesToCellName(1, len(result.Rows)+3)
+		note := fmt.Sprintf("Result truncated to the first %d rows.", len(result.Rows))
+		if err := f.SetCellValue(sheetName, cell, note); err != nil {
+			return fmt.Errorf("failed to write truncation note: %w", err)
+		}
+	}
+
+	return f.Write(w)
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	_ "modernc.org/sqlite"
+)
+
+func newTestQueryRunner(t *testing.T, maxRows int) *QueryRunner {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	statements := []string{
+		"CREATE TABLE contracts (number TEXT, premium INTEGER)",
+		"INSERT INTO contracts VALUES ('EP-228960453-123', 100), ('EP-228209382-456', 200), ('EP-1-1', 300)",
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	return NewQueryRunner(db, time.Second, maxRows)
+}
+
+func TestValidateReadOnlyQuery(t *testing.T) {
+	if err := validateReadOnlyQuery(writeSQLScript(t, SQLStyleInline, "228960453-123")); err != nil {
+		t.Errorf("Generated script should be accepted: %v", err)
+	}
+	if err := validateReadOnlyQuery("SELECT * FROM contracts WHERE number = 'DROP TABLE'"); err != nil {
+		t.Errorf("Keywords inside string literals should be ignored: %v", err)
+	}
+
+	rejected := []string{
+		"DELETE FROM contracts",
+		"SELECT 1; DROP TABLE contracts",
+		"SELECT * INTO copy FROM contracts",
+		"/* SELECT */ UPDATE contracts SET premium = 0",
+		"SELECT 1 -- comment",
+		"SELECT 'unterminated",
+	}
+	for _, query := range rejected {
+		if err := validateReadOnlyQuery(query); !errors.Is(err, ErrQueryNotReadOnly) {
+			t.Errorf("validateReadOnlyQuery(%q) = %v, want ErrQueryNotReadOnly", query, err)
+		}
+	}
+}
+
+// Comment markers inside cell values must not hide code between literals.
+func TestValidateReadOnlyQuery_CommentInjection(t *testing.T) {
+	numbers := []string{
+		"EP-A/*",
+		"EP-B',0)) AS x(a,b); DELETE FROM contract; SELECT * FROM (VALUES(('C",
+		"EP-*/",
+	}
+
+	script := buildSQLScript(numbers)
+	if !strings.Contains(script, "('EP-B'',0)) AS x(a,b); DELETE FROM contract; SELECT * FROM (VALUES((''C', 1)") {
+		t.Errorf("numbers should be escaped literals:\n%s", script)
+	}
+	if err := validateReadOnlyQuery(script); err != nil {
+		t.Errorf("escaped script should be accepted: %v", err)
+	}
+
+	unescaped := "SELECT sort_order.number FROM (VALUES ('" + strings.Join(numbers, "', 0), ('") + "', 0)) AS sort_order(number, sort_seq)"
+	if err := validateReadOnlyQuery(unescaped); !errors.Is(err, ErrQueryNotReadOnly) {
+		t.Errorf("unescaped script = %v, want ErrQueryNotReadOnly", err)
+	}
+}
+
+func TestQueryRunnerRun(t *testing.T) {
+	runner := newTestQueryRunner(t, 10)
+
+	result, err := runner.Run(context.Background(), "SELECT number, premium FROM contracts ORDER BY premium")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(result.Columns) != 2 || result.Columns[0] != "number" {
+		t.Errorf("Unexpected columns: %v", result.Columns)
+	}
+	if len(result.Rows) != 3 || result.Truncated {
+		t.Fatalf("Expected 3 complete rows, got %d (truncated %v)", len(result.Rows), result.Truncated)
+	}
+	if result.Rows[0][0] != "EP-228960453-123" {
+		t.Errorf("Unexpected first value: %v", result.Rows[0][0])
+	}
+
+	if _, err := runner.Run(context.Background(), "DELETE FROM contracts"); !errors.Is(err, ErrQueryNotReadOnly) {
+		t.Errorf("Write query should be rejected, got %v", err)
+	}
+}
+
+func TestQueryRunnerTruncates(t *testing.T) {
+	runner := newTestQueryRunner(t, 2)
+
+	result, err := runner.Run(context.Background(), "SELECT number FROM contracts")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(result.Rows) != 2 || !result.Truncated {
+		t.Errorf("Expected 2 rows and a truncated result, got %d (truncated %v)", len(result.Rows), result.Truncated)
+	}
+}
+
+func TestWriteQueryResult(t *testing.T) {
+	result := &QueryResult{
+		Columns:   []string{"number", "premium"},
+		Rows:      [][]any{{"EP-228960453-123", int64(100)}},
+		Truncated: true,
+	}
+
+	var buf bytes.Buffer
+	if err := writeQueryResult(&buf, result); err != nil {
+		t.Fatalf("writeQueryResult failed: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("Output is not a valid workbook: %v", err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows("Results")
+	if err != nil {
+		t.Fatalf("Failed to read Results sheet: %v", err)
+	}
+	if len(rows) != 4 || rows[1][0] != "EP-228960453-123" || rows[1][1] != "100" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// cellRegion is a rectangle of cells that scanning is restricted to. Bounds
+// are one-based; a zero bound is open, so "A:F" covers whole columns and
+// "5:200" whole rows. An empty sheet applies the region to every sheet.
+type cellRegion struct {
+	sheet              string
+	startCol, startRow int
+	endCol, endRow     int
+}
+
+// parseCellRegion reads references such as "A5:F200", "$A$5:$F$200",
+// "'Data 2024'!A5:F200", "A:F" or "5:200".
+func parseCellRegion(ref string) (cellRegion, error) {
+	var region cellRegion
+
+	cells := ref
+	if i := strings.LastIndex(ref, "!"); i != -1 {
+		region.sheet = strings.ReplaceAll(strings.Trim(ref[:i], "'"), "''", "'")
+		cells = ref[i+1:]
+	}
+	cells = strings.ReplaceAll(cells, "$", "")
+
+	start, end, found := strings.Cut(cells, ":")
+	if !found {
+		end = start
+	}
+
+	var err error
+	if region.startCol, region.startRow, err = parseRegionBound(start); err != nil {
+		return cellRegion{}, fmt.Errorf("invalid range %q: %w", ref, err)
+	}
+	if region.endCol, region.endRow, err = parseRegionBound(end); err != nil {
+		return cellRegion{}, fmt.Errorf("invalid range %q: %w", ref, err)
+	}
+	if region.endCol != 0 && region.startCol > region.endCol || region.endRow != 0 && region.startRow > region.endRow {
+		return cellRegion{}, fmt.Errorf("invalid range %q: start is after end", ref)
+	}
+
+	return region, nil
+}
+
+// parseRegionBound splits "F200", "F" or "200" into column and row numbers.
+func parseRegionBound(bound string) (col, row int, err error) {
+	letters := strings.TrimRight(strings.ToUpper(bound), "0123456789")
+	digits := bound[len(letters):]
+	if letters == "" && digits == "" {
+		return 0, 0, fmt.Errorf("empty bound")
+	}
+
+	if letters != "" {
+		if col, err = excelize.ColumnNameToNumber(letters); err != nil {
+			return 0, 0, err
+		}
+	}
+	if digits != "" {
+		if row, err = strconv.Atoi(digits); err != nil || row < 1 {
+			return 0, 0, fmt.Errorf("invalid row %q", digits)
+		}
+	}
+
+	return col, row, nil
+}
+
+func (c cellRegion) containsRow(row int) bool {
+	return (c.startRow == 0 || row >= c.startRow) && (c.endRow == 0 || row <= c.endRow)
+}
+
+// clip blanks the cells left of the region and drops those right of it.
+func (c cellRegion) clip(cells []string) []string {
+	if c.endCol != 0 && len(cells) > c.endCol {
+		cells = cells[:c.endCol]
+	}
+
+	clipped := make([]string, len(cells))
+	for i := max(c.startCol-1, 0); i < len(cells); i++ {
+		clipped[i] = cells[i]
+	}
+	return clipped
+}
+
+// sheetScan tracks the rule's region while the rows of one sheet pass
+// through scanRow, so both readers apply it the same way.
+type sheetScan struct {
+	region      *cellRegion
+	stopAtEmpty bool
+	// headerRow is the 1-based header row stopAtEmpty counts from; 0 means
+	// the first row with a target match.
+	headerRow int
+	anchored  bool
+	lastRow   int
+	ended     bool
+}
+
+// visible returns the part of the row inside the region, or false when the
+// row is outside it. With stopAtEmpty the sheet ends at the first empty or
+// missing row after the header row, or after the first matched row when
+// no header row is set, so a title and a blank line above the header do
+// not end the sheet before the data.
+func (s *sheetScan) visible(rowIndex int, cells []string) ([]string, bool) {
+	if s.ended {
+		return nil, false
+	}
+
+	row := rowIndex + 1
+	if s.region != nil {
+		if !s.region.containsRow(row) {
+			return nil, false
+		}
+		cells = s.region.clip(cells)
+	}
+
+	if s.stopAtEmpty {
+		if s.anchored && (isEmptyRow(cells) || row > s.lastRow+1) {
+			s.ended = true
+			return nil, false
+		}
+		if s.headerRow > 0 && row >= s.headerRow {
+			s.anchored = true
+		}
+		s.lastRow = row
+	}
+
+	return cells, true
+}
+
+// anchor marks the current row as the one the sheet's end is counted
+// from, unless a header row already is.
+func (s *sheetScan) anchor() {
+	s.anchored = true
+}
+
+func isEmptyRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// namedRegion resolves a defined name or table on a sheet. Sheet-scoped
+// names win over workbook-scoped ones; tables maps lower-cased table names
+// to their references on this sheet.
+func namedRegion(definedNames []excelize.DefinedName, tables map[string]string, sheetName, name string) (cellRegion, bool) {
+	var found *cellRegion
+	for _, definedName := range definedNames {
+		if !strings.EqualFold(definedName.Name, name) {
+			continue
+		}
+		if definedName.Scope != sheetName && definedName.Scope != "Workbook" {
+			continue
+		}
+
+		region, err := parseCellRegion(strings.TrimPrefix(definedName.RefersTo, "="))
+		if err != nil || !strings.EqualFold(region.sheet, sheetName) {
+			continue
+		}
+		if found == nil || definedName.Scope == sheetName {
+			found = &region
+		}
+	}
+	if found != nil {
+		return *found, true
+	}
+
+	if ref, ok := tables[strings.ToLower(name)]; ok {
+		if region, err := parseCellRegion(ref); err == nil {
+			region.sheet = sheetName
+			return region, true
+		}
+	}
+
+	return cellRegion{}, false
+}
+
+// sheetTables lists the tables of a sheet by lower-cased name and display
+// name, read from the sheet's relationships without parsing the sheet.
+func (p *xlsxPackage) sheetTables(sheetName string) (map[string]string, error) {
+	tables := make(map[string]string)
+
+	sheetPath, ok := p.sheetPaths[sheetName]
+	if !ok {
+		return tables, nil
+	}
+
+	var relationships struct {
+		Relationships []struct {
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	relsPath := path.Join(path.Dir(sheetPath), "_rels", path.Base(sheetPath)+".rels")
+	if err := p.decodePart(relsPath, &relationships); err != nil {
+		// A sheet without relationships has no tables.
+		return tables, nil
+	}
+
+	for _, rel := range relationships.Relationships {
+		if !strings.HasSuffix(rel.Type, "/table") {
+			continue
+		}
+
+		target := path.Join(path.Dir(sheetPath), rel.Target)
+		if strings.HasPrefix(rel.Target, "/") {
+			target = strings.TrimPrefix(rel.Target, "/")
+		}
+
+		var table struct {
+			Name        string `xml:"name,attr"`
+			DisplayName string `xml:"displayName,attr"`
+			Ref         string `xml:"ref,attr"`
+		}
+		if err := p.decodePart(target, &table); err != nil {
+			return nil, err
+		}
+		tables[strings.ToLower(table.Name)] = table.Ref
+		tables[strings.ToLower(table.DisplayName)] = table.Ref
+	}
+
+	return tables, nil
+}
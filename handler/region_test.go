@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestParseCellRegion(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want cellRegion
+	}{
+		{"A5:F200", cellRegion{startCol: 1, startRow: 5, endCol: 6, endRow: 200}},
+		{"$B$2:$C$10", cellRegion{startCol: 2, startRow: 2, endCol: 3, endRow: 10}},
+		{"'Data ''24'!A1:C3", cellRegion{sheet: "Data '24", startCol: 1, startRow: 1, endCol: 3, endRow: 3}},
+		{"B:D", cellRegion{startCol: 2, endCol: 4}},
+		{"5:200", cellRegion{startRow: 5, endRow: 200}},
+	}
+	for _, tt := range tests {
+		got, err := parseCellRegion(tt.ref)
+		if err != nil {
+			t.Errorf("parseCellRegion(%q) failed: %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCellRegion(%q) = %+v, want %+v", tt.ref, got, tt.want)
+		}
+	}
+
+	for _, ref := range []string{"", "A5:", "F1:A1", "A0:B2", "Sheet1!"} {
+		if _, err := parseCellRegion(ref); err == nil {
+			t.Errorf("parseCellRegion(%q) should fail", ref)
+		}
+	}
+}
+
+func TestSheetScan(t *testing.T) {
+	region := cellRegion{startCol: 2, startRow: 2, endCol: 3}
+	scan := sheetScan{region: &region, stopAtEmpty: true}
+
+	rows := []struct {
+		index int
+		cells []string
+		want  []string
+		ok    bool
+	}{
+		{0, []string{"title", "", ""}, nil, false},
+		{1, []string{"", "", ""}, []string{"", "", ""}, true},
+		{2, []string{"x", "header", "h2", "beyond"}, []string{"", "header", "h2"}, true},
+		{3, []string{"", "a", "b"}, []string{"", "a", "b"}, true},
+		{4, []string{"note", "", ""}, nil, false},
+		{5, []string{"", "total", "9"}, nil, false},
+	}
+	for _, row := range rows {
+		got, ok := scan.visible(row.index, row.cells)
+		if ok != row.ok || !reflect.DeepEqual(got, row.want) {
+			t.Errorf("row %d: visible = %v, %v; want %v, %v", row.index+1, got, ok, row.want, row.ok)
+		}
+		if row.index == 3 {
+			scan.anchor() // the data row has a match
+		}
+	}
+
+	gap := sheetScan{stopAtEmpty: true}
+	gap.visible(0, []string{"header"})
+	gap.visible(1, []string{"data"})
+	gap.anchor()
+	if _, ok := gap.visible(4, []string{"totals"}); ok {
+		t.Error("A missing row after the data should end the sheet")
+	}
+
+	header := sheetScan{stopAtEmpty: true, headerRow: 3}
+	for index, cells := range [][]string{{"title"}, {""}, {"header"}} {
+		if _, ok := header.visible(index, cells); !ok {
+			t.Errorf("row %d up to the header row should be visible", index+1)
+		}
+	}
+	if _, ok := header.visible(3, []string{""}); ok {
+		t.Error("An empty row after the header row should end the sheet")
+	}
+}
+
+func TestStopAtEmptyRow_TitleAboveHeader(t *testing.T) {
+	newResult := func(headerRow int) *ExtractionResult {
+		rule := builtinRule("")
+		rule.StopAtEmptyRow = true
+		rule.HeaderRow = headerRow
+		if err := rule.compile(); err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		result := newExtractionResult("register.xlsx", rule)
+		result.beginSheet("Sheet1")
+		for index, cells := range [][]string{
+			{"Register of contracts"},
+			{},
+			{"Agent", "Series", "Number"},
+			{DefaultTargetText, "228960453", "123"},
+			{DefaultTargetText, "228960454", "456"},
+			{},
+			{DefaultTargetText, "999999999", "000"},
+		} {
+			result.scanRow(index, cells)
+		}
+		return result
+	}
+
+	result := newResult(0)
+	if len(result.Matches) != 2 || len(result.Warnings) != 0 {
+		t.Errorf("the blank row under the title should not end the sheet: %d matches, warnings %v", len(result.Matches), result.Warnings)
+	}
+
+	// A header row set on the title ends the sheet at the blank row below.
+	result = newResult(1)
+	if len(result.Matches) != 0 {
+		t.Errorf("expected no matches, got %d", len(result.Matches))
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "ended at empty row 2 before any contract") {
+		t.Errorf("expected a warning about the early end, got %v", result.Warnings)
+	}
+}
+
+func TestReadXlsxFile_Region(t *testing.T) {
+	h := NewHandler()
+
+	f := excelize.NewFile()
+	rows := [][]any{
+		{"Register"},
+		{"Agent", "Series", "Number"},
+		{DefaultTargetText, "228960453", "123"},
+		{DefaultTargetText, "228960454", "456"},
+		{},
+		{DefaultTargetText, "999999999", "000"},
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		f.SetSheetRow("Sheet1", cell, &row)
+	}
+	f.AddTable("Sheet1", &excelize.Table{Range: "A2:C4", Name: "Contracts"})
+	f.SetDefinedName(&excelize.DefinedName{Name: "FirstContract", RefersTo: "Sheet1!$A$3:$C$3"})
+
+	path := filepath.Join(t.TempDir(), "region.xlsx")
+	if err := f.SaveAs(path); err != nil {
+		t.Fatalf("failed to save workbook: %v", err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name   string
+		adjust func(*Rule)
+		want   int
+	}{
+		{"whole sheet", func(r *Rule) {}, 3},
+		{"range", func(r *Rule) { r.Range = "A1:C4" }, 2},
+		{"stop at empty row", func(r *Rule) { r.StopAtEmptyRow = true }, 2},
+		{"table", func(r *Rule) { r.Table = "contracts" }, 2},
+		{"defined name", func(r *Rule) { r.Table = "FirstContract" }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := h.defaultRule()
+			tt.adjust(&rule)
+			if err := rule.compile(); err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+
+			result, err := h.readXlsxFile(path, rule, "")
+			if err != nil {
+				t.Fatalf("readXlsxFile failed: %v", err)
+			}
+			if len(result.Matches) != tt.want {
+				t.Errorf("Expected %d matches, got %d", tt.want, len(result.Matches))
+			}
+		})
+	}
+}
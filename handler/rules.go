@@ -0,0 +1,396 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	DefaultRuleName   = "bbs"
+	DefaultRuleFormat = "{series}-{number}"
+)
+
+var rulePlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// Rule describes one product line: the text that marks its rows, the fields
+// read from the cells to the right of the match and how they are composed
+// into a contract number, e.g. Fields ["series", "number", "suffix"] with
+// Format "{series}-{number}/{suffix}".
+type Rule struct {
+	Name       string   `json:"name"`
+	TargetText string   `json:"target_text"`
+	Prefix     string   `json:"prefix"`
+	Fields     []string `json:"fields"`
+	Format     string   `json:"format"`
+	// Columns are further cells of the row carried to every output.
+	Columns []RuleColumn `json:"columns,omitempty"`
+	// Pattern validates the composed number without prefix; empty disables
+	// validation.
+	Pattern string `json:"pattern,omitempty"`
+	// Match lists the matcher modes used to find TargetText; empty means an
+	// exact substring match. MaxDistance applies to the fuzzy mode.
+	Match       []string `json:"match,omitempty"`
+	MaxDistance int      `json:"max_distance,omitempty"`
+
+	// Targets searches several texts in one pass, such as agent names, and
+	// replaces TargetText. Every match records the target that found it;
+	// TargetOutput is "column" (the default) to add it as a column of one
+	// output, or "separate" for one script or sheet per target.
+	Targets      []string `json:"targets,omitempty"`
+	TargetOutput string   `json:"target_output,omitempty"`
+
+	// Reading options for .xlsx workbooks: fill merged regions with their
+	// top-left value, recalculate formulas and read values without number
+	// formats applied. All are off by default; recalculation in particular
+	// loads the whole sheet and is slow on large registers.
+	MergedCells      bool `json:"merged_cells,omitempty"`
+	EvaluateFormulas bool `json:"evaluate_formulas,omitempty"`
+	RawValues        bool `json:"raw_values,omitempty"`
+
+	// Sheets and ExcludeSheets are case-insensitive glob patterns such as
+	// "Region*" deciding which sheets are scanned; no Sheets means all.
+	Sheets        []string `json:"sheets,omitempty"`
+	ExcludeSheets []string `json:"exclude_sheets,omitempty"`
+
+	// Region restricts scanning within a sheet: Range is a reference such as
+	// "A5:F200" or "Data!A5:F200", Table a defined name or table (.xlsx
+	// only). StopAtEmptyRow ends a sheet at the first empty row after the
+	// header, which keeps totals and notes below the data out. The header is
+	// HeaderRow (1-based) when set, otherwise the first row with a match.
+	Range          string `json:"range,omitempty"`
+	Table          string `json:"table,omitempty"`
+	StopAtEmptyRow bool   `json:"stop_at_empty_row,omitempty"`
+	HeaderRow      int    `json:"header_row,omitempty"`
+
+	// Duplicates is "keep" (the default), "first" or "last": whether a
+	// contract number found more than once is listed every time or only at
+	// its first or last occurrence. Order is "file" (the default) or
+	// "number" and decides the order of the output rows.
+	Duplicates string `json:"duplicates,omitempty"`
+	Order      string `json:"order,omitempty"`
+
+	pattern  *regexp.Regexp
+	matchers []*Matcher
+	region   *cellRegion
+	// selectedSheets are the sheets picked for one request; when set they
+	// replace the patterns.
+	selectedSheets []string
+}
+
+// builtinRule is the original BBS register layout: two cells after the
+// target text joined with a dash and prefixed with EP-.
+func builtinRule(pattern string) Rule {
+	return Rule{
+		Name:       DefaultRuleName,
+		TargetText: DefaultTargetText,
+		Prefix:     DefaultContractPrefix,
+		Fields:     []string{"series", "number"},
+		Format:     DefaultRuleFormat,
+		Pattern:    pattern,
+		Match:      DefaultMatchModes,
+	}
+}
+
+// LoadRules reads the rule list from a JSON file. A missing file yields the
+// default rule validated with defaultPattern.
+func LoadRules(path, defaultPattern string) ([]Rule, error) {
+	fallback := builtinRule(defaultPattern)
+	if err := fallback.compile(); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return []Rule{fallback}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Rule{fallback}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("rules file %s defines no rules", path)
+	}
+
+	seen := make(map[string]bool)
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if seen[rules[i].Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name %q", i+1, rules[i].Name)
+		}
+		seen[rules[i].Name] = true
+	}
+
+	return rules, nil
+}
+
+// compile validates the rule and prepares its pattern. An empty format joins
+// all fields with a dash.
+func (r *Rule) compile() error {
+	r.Name = strings.ToLower(strings.TrimSpace(r.Name))
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.TargetText == "" && len(r.Targets) == 0 {
+		return fmt.Errorf("rule %s: target_text or targets is required", r.Name)
+	}
+	if slices.Contains(r.Targets, "") {
+		return fmt.Errorf("rule %s: targets cannot be empty", r.Name)
+	}
+	r.TargetOutput = strings.ToLower(r.TargetOutput)
+	if r.TargetOutput != "" && r.TargetOutput != TargetOutputColumn && r.TargetOutput != TargetOutputSeparate {
+		return fmt.Errorf("rule %s: unknown target_output %q", r.Name, r.TargetOutput)
+	}
+	if len(r.Fields) == 0 {
+		return fmt.Errorf("rule %s: at least one field is required", r.Name)
+	}
+
+	if r.Format == "" {
+		placeholders := make([]string, len(r.Fields))
+		for i, field := range r.Fields {
+			placeholders[i] = "{" + field + "}"
+		}
+		r.Format = strings.Join(placeholders, "-")
+	}
+
+	columnNames := make(map[string]bool)
+	for i := range r.Columns {
+		if err := r.Columns[i].compile(); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		if columnNames[r.Columns[i].Name] {
+			return fmt.Errorf("rule %s: duplicate column %q", r.Name, r.Columns[i].Name)
+		}
+		columnNames[r.Columns[i].Name] = true
+	}
+
+	for _, placeholder := range rulePlaceholder.FindAllStringSubmatch(r.Format, -1) {
+		if r.fieldIndex(placeholder[1]) == -1 {
+			return fmt.Errorf("rule %s: format uses unknown field %q", r.Name, placeholder[1])
+		}
+	}
+
+	if err := r.compileTargets(); err != nil {
+		return fmt.Errorf("rule %s: %w", r.Name, err)
+	}
+
+	for _, sheetPattern := range append(append([]string{}, r.Sheets...), r.ExcludeSheets...) {
+		if _, err := path.Match(strings.ToLower(sheetPattern), ""); err != nil {
+			return fmt.Errorf("rule %s: invalid sheet pattern %q: %w", r.Name, sheetPattern, err)
+		}
+	}
+
+	r.region = nil
+	if r.HeaderRow < 0 {
+		return fmt.Errorf("rule %s: header_row must be a row number", r.Name)
+	}
+	if r.Range != "" && r.Table != "" {
+		return fmt.Errorf("rule %s: range and table cannot be combined", r.Name)
+	}
+	if r.Range != "" {
+		region, err := parseCellRegion(r.Range)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		r.region = &region
+	}
+
+	r.Duplicates = strings.ToLower(r.Duplicates)
+	r.Order = strings.ToLower(r.Order)
+	if err := validateArrangement(r.Duplicates, r.Order); err != nil {
+		return fmt.Errorf("rule %s: %w", r.Name, err)
+	}
+
+	r.pattern = nil
+	if r.Pattern != "" {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid pattern: %w", r.Name, err)
+		}
+		r.pattern = pattern
+	}
+
+	return nil
+}
+
+func (r Rule) fieldIndex(name string) int {
+	for i, field := range r.Fields {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Compose fills the format expression with values given in field order.
+func (r Rule) Compose(values []string) string {
+	return rulePlaceholder.ReplaceAllStringFunc(r.Format, func(placeholder string) string {
+		index := r.fieldIndex(placeholder[1 : len(placeholder)-1])
+		if index == -1 || index >= len(values) {
+			return ""
+		}
+		return values[index]
+	})
+}
+
+// compileTargets prepares a matcher for every target.
+func (r *Rule) compileTargets() error {
+	targets := r.targetList()
+	matchers := make([]*Matcher, len(targets))
+	for i, target := range targets {
+		matcher, err := NewMatcher(target, r.Match, r.MaxDistance)
+		if err != nil {
+			return err
+		}
+		matchers[i] = matcher
+	}
+	r.matchers = matchers
+	return nil
+}
+
+// targetList is the texts the rule searches for: Targets, or TargetText.
+func (r Rule) targetList() []string {
+	if len(r.Targets) > 0 {
+		return r.Targets
+	}
+	return []string{r.TargetText}
+}
+
+// matchTarget returns the first target a cell contains.
+func (r Rule) matchTarget(cell string) (string, bool) {
+	targets := r.targetList()
+	for i, target := range targets {
+		if r.matchers == nil {
+			if strings.Contains(cell, target) {
+				return target, true
+			}
+			continue
+		}
+		if r.matchers[i].Match(cell) {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+func (r Rule) validNumber(contract string) bool {
+	return r.pattern == nil || r.pattern.MatchString(contract)
+}
+
+// withSheetSelection restricts a copy of the rule to the named sheets. An
+// empty selection keeps the rule's patterns.
+func (r Rule) withSheetSelection(sheets []string) Rule {
+	r.selectedSheets = sheets
+	return r
+}
+
+// withArrangement overrides the rule's duplicate and order options for one
+// request; empty values keep the rule's own.
+func (r Rule) withArrangement(duplicates, order string) Rule {
+	if duplicates != "" {
+		r.Duplicates = duplicates
+	}
+	if order != "" {
+		r.Order = order
+	}
+	return r
+}
+
+// withTargets replaces the rule's targets for one request. The rule is kept
+// unchanged if the targets cannot be compiled.
+func (r Rule) withTargets(targets []string) Rule {
+	if len(targets) == 0 {
+		return r
+	}
+
+	override := r
+	override.Targets = targets
+	if err := override.compileTargets(); err != nil {
+		return r
+	}
+	return override
+}
+
+// includesSheet reports whether a sheet is scanned: it must be selected, or
+// match an include pattern (if any) and no exclude pattern. A range naming
+// a sheet limits scanning to that sheet.
+func (r Rule) includesSheet(sheetName string) bool {
+	if len(r.selectedSheets) > 0 {
+		return slices.Contains(r.selectedSheets, sheetName)
+	}
+	if r.region != nil && r.region.sheet != "" && !strings.EqualFold(r.region.sheet, sheetName) {
+		return false
+	}
+
+	name := strings.ToLower(sheetName)
+	matchesAny := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(r.Sheets) > 0 && !matchesAny(r.Sheets) {
+		return false
+	}
+	return !matchesAny(r.ExcludeSheets)
+}
+
+func (h *Handler) defaultRule() Rule {
+	if len(h.rules) == 0 {
+		return builtinRule("")
+	}
+	return h.rules[0]
+}
+
+func (h *Handler) ruleByName(name string) (Rule, bool) {
+	for _, rule := range h.rules {
+		if rule.Name == strings.ToLower(name) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// resolveRule picks the rule for a request: a rule name in the request text
+// (bare or as "rule=<name>") wins, then the named fallback, then the first
+// configured rule.
+func (h *Handler) resolveRule(requestText, fallback string) Rule {
+	for _, word := range strings.Fields(strings.ToLower(requestText)) {
+		if rule, ok := h.ruleByName(strings.TrimPrefix(word, "rule=")); ok {
+			return rule
+		}
+	}
+
+	if rule, ok := h.ruleByName(fallback); ok {
+		return rule
+	}
+
+	return h.defaultRule()
+}
+
+// uploadRule is the rule an upload is read with, including the targets and
+// sheets the user picked for it and the duplicate and order options of its
+// caption.
+func (h *Handler) uploadRule(upload HistoryRecord) Rule {
+	return h.resolveRule("", upload.Rule).
+		withTargets(upload.Targets).
+		withSheetSelection(upload.Sheets).
+		withArrangement(upload.Duplicates, upload.Order)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing file uses the built-in rule", func(t *testing.T) {
+		rules, err := LoadRules(filepath.Join(dir, "missing.json"), DefaultContractPattern)
+		if err != nil {
+			t.Fatalf("LoadRules failed: %v", err)
+		}
+		if len(rules) != 1 || rules[0].Name != DefaultRuleName || rules[0].Prefix != DefaultContractPrefix {
+			t.Errorf("Unexpected rules: %+v", rules)
+		}
+	})
+
+	t.Run("custom format", func(t *testing.T) {
+		path := filepath.Join(dir, "rules.json")
+		content := `[{"name": "Travel", "target_text": "TRAVEL", "prefix": "TR/", "fields": ["series", "number", "suffix"], "format": "{series}-{number}/{suffix}"}]`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write rules: %v", err)
+		}
+
+		rules, err := LoadRules(path, DefaultContractPattern)
+		if err != nil {
+			t.Fatalf("LoadRules failed: %v", err)
+		}
+		if rules[0].Name != "travel" {
+			t.Errorf("Rule names should be lower-cased, got %q", rules[0].Name)
+		}
+		if got := rules[0].Compose([]string{"AB", "123", "7"}); got != "AB-123/7" {
+			t.Errorf("Compose() = %q, want %q", got, "AB-123/7")
+		}
+	})
+
+	invalid := map[string]string{
+		"unknown field":  `[{"name": "a", "target_text": "A", "fields": ["number"], "format": "{series}-{number}"}]`,
+		"no fields":      `[{"name": "a", "target_text": "A"}]`,
+		"duplicate name": `[{"name": "a", "target_text": "A", "fields": ["n"]}, {"name": "A", "target_text": "B", "fields": ["n"]}]`,
+		"bad pattern":    `[{"name": "a", "target_text": "A", "fields": ["n"], "pattern": "("}]`,
+		"bad sheet glob": `[{"name": "a", "target_text": "A", "fields": ["n"], "exclude_sheets": ["[Summary"]}]`,
+		"bad order":      `[{"name": "a", "target_text": "A", "fields": ["n"], "order": "random"}]`,
+		"empty target":   `[{"name": "a", "targets": ["A", ""], "fields": ["n"]}]`,
+		"bad target out": `[{"name": "a", "targets": ["A", "B"], "fields": ["n"], "target_output": "zip"}]`,
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_")+".json")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write rules: %v", err)
+			}
+			if _, err := LoadRules(path, DefaultContractPattern); err == nil {
+				t.Error("LoadRules should reject the rules")
+			}
+		})
+	}
+}
+
+func TestExtractXlsx_CustomRule(t *testing.T) {
+	h := &Handler{}
+	rule := Rule{Name: "single", TargetText: "KASKO", Prefix: "KS-", Fields: []string{"number"}}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetCellValue("Sheet1", "A1", "KASKO")
+	f.SetCellValue("Sheet1", "B1", "778899")
+	f.SetCellValue("Sheet1", "A2", "ББС ІНШУРАНС")
+	f.SetCellValue("Sheet1", "B2", "228960453")
+	f.SetCellValue("Sheet1", "C2", "123")
+
+	testFile := filepath.Join(t.TempDir(), "kasko.xlsx")
+	if err := f.SaveAs(testFile); err != nil {
+		t.Fatalf("Failed to save test file: %v", err)
+	}
+
+	result, err := h.readExcelFile(testFile, rule)
+	if err != nil {
+		t.Fatalf("readExcelFile failed: %v", err)
+	}
+
+	if numbers := result.Numbers(); len(numbers) != 1 || numbers[0] != "KS-778899" {
+		t.Fatalf("Expected only KS-778899, got %v", numbers)
+	}
+
+	script := buildSQLScript(result.Numbers())
+	if !strings.Contains(script, "('KS-778899', 0)") || strings.Contains(script, "EP-") {
+		t.Errorf("Script should use the rule's prefix, got:\n%s", script)
+	}
+}
+
+func TestResolveRule(t *testing.T) {
+	h := &Handler{rules: []Rule{builtinRule(""), {Name: "travel"}}}
+
+	tests := []struct {
+		text, fallback, want string
+	}{
+		{"", "", DefaultRuleName},
+		{"csv travel", "", "travel"},
+		{"rule=travel", "", "travel"},
+		{"", "travel", "travel"},
+		{"", DefaultTargetText, DefaultRuleName},
+	}
+	for _, tt := range tests {
+		if got := h.resolveRule(tt.text, tt.fallback).Name; got != tt.want {
+			t.Errorf("resolveRule(%q, %q) = %q, want %q", tt.text, tt.fallback, got, tt.want)
+		}
+	}
+}
+
+func TestRuleIncludesSheet(t *testing.T) {
+	rule := Rule{Sheets: []string{"region*", "Kyiv"}, ExcludeSheets: []string{"*archive*"}}
+
+	tests := []struct {
+		sheet string
+		want  bool
+	}{
+		{"Region North", true},
+		{"KYIV", true},
+		{"Region archive 2023", false},
+		{"Summary", false},
+	}
+	for _, tt := range tests {
+		if got := rule.includesSheet(tt.sheet); got != tt.want {
+			t.Errorf("includesSheet(%q) = %v, want %v", tt.sheet, got, tt.want)
+		}
+	}
+
+	selected := rule.withSheetSelection([]string{"Summary"})
+	if !selected.includesSheet("Summary") || selected.includesSheet("Region North") {
+		t.Error("A sheet selection should replace the rule's patterns")
+	}
+	if !(Rule{}).includesSheet("Anything") {
+		t.Error("A rule without patterns should include every sheet")
+	}
+}
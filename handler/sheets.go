@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/extrame/xls"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	// SheetPickerKeyword in a file caption asks for the sheet picker.
+	SheetPickerKeyword = "sheets"
+
+	sheetCallbackProcess = "go"
+	sheetCallbackAll     = "all"
+)
+
+// sheetPick is an upload waiting for the user to choose its sheets.
+type sheetPick struct {
+	upload   HistoryRecord
+	sheets   []string
+	selected []bool
+}
+
+func wantsSheetPicker(caption string) bool {
+	for _, word := range strings.Fields(strings.ToLower(caption)) {
+		if word == SheetPickerKeyword {
+			return true
+		}
+	}
+	return false
+}
+
+// workbookSheetNames lists the sheets of a workbook in their order.
+func workbookSheetNames(filePath string) ([]string, error) {
+	if workbookKindOf(filePath) == kindXLS {
+		xlsFile, err := xls.Open(filePath, "utf-8")
+		if err != nil {
+			return nil, fmt.Errorf("failed to open XLS file: %w", err)
+		}
+
+		var names []string
+		for sheetIndex := 0; sheetIndex < xlsFile.NumSheets(); sheetIndex++ {
+			if sheet := xlsFile.GetSheet(sheetIndex); sheet != nil {
+				names = append(names, sheet.Name)
+			}
+		}
+		return names, nil
+	}
+
+	f, err := excelize.OpenFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Excel file: %w", err)
+	}
+	defer f.Close()
+
+	return f.GetSheetList(), nil
+}
+
+// offerSheetPicker lists the workbook's sheets as buttons, preselecting those
+// the rule would scan. It returns false when there is nothing to pick, e.g.
+// for a single sheet or a workbook that cannot be listed without a password;
+// the upload is then processed as usual.
+func (h *Handler) offerSheetPicker(bot *tgbotapi.BotAPI, upload HistoryRecord) bool {
+	if isArchiveFile(upload.FileName) {
+		return false
+	}
+
+	sheets, err := workbookSheetNames(upload.FilePath)
+	if err != nil {
+		log.Printf("Error listing sheets of %s: %v", upload.FilePath, err)
+		return false
+	}
+	if len(sheets) < 2 {
+		return false
+	}
+
+	rule := h.resolveRule("", upload.Rule)
+	pick := &sheetPick{upload: upload, sheets: sheets, selected: make([]bool, len(sheets))}
+	for i, sheet := range sheets {
+		pick.selected[i] = rule.includesSheet(sheet)
+	}
+	h.sheetPicks[upload.ChatID] = pick
+
+	msg := tgbotapi.NewMessage(upload.ChatID, fmt.Sprintf(TextSheetPickerHeader, upload.FileName, len(sheets)))
+	msg.ReplyMarkup = pick.keyboard()
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+
+	return true
+}
+
+// keyboard shows one toggle button per sheet. Callback data carries the
+// sheet index, since names may exceed Telegram's 64-byte limit.
+func (p *sheetPick) keyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, sheet := range p.sheets {
+		label := "⬜ " + sheet
+		if p.selected[i] {
+			label = "✅ " + sheet
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, CallbackSheetPrefix+strconv.Itoa(i)),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(TextSheetProcessButton, CallbackSheetPrefix+sheetCallbackProcess),
+		tgbotapi.NewInlineKeyboardButtonData(TextSheetAllButton, CallbackSheetPrefix+sheetCallbackAll),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (p *sheetPick) selectedSheets() []string {
+	var sheets []string
+	for i, sheet := range p.sheets {
+		if p.selected[i] {
+			sheets = append(sheets, sheet)
+		}
+	}
+	return sheets
+}
+
+func (h *Handler) handleSheetCallback(query *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	chatID := query.Message.Chat.ID
+	action := strings.TrimPrefix(query.Data, CallbackSheetPrefix)
+
+	pick, found := h.sheetPicks[chatID]
+	if !found {
+		h.removeKeyboard(bot, query.Message)
+		msg := tgbotapi.NewMessage(chatID, TextSheetPickExpired)
+		bot.Send(msg)
+		return
+	}
+
+	switch action {
+	case sheetCallbackAll:
+		// Every sheet, regardless of the rule's patterns.
+		pick.upload.Sheets = pick.sheets
+	case sheetCallbackProcess:
+		pick.upload.Sheets = pick.selectedSheets()
+		if len(pick.upload.Sheets) == 0 {
+			msg := tgbotapi.NewMessage(chatID, TextSheetNoneSelected)
+			bot.Send(msg)
+			return
+		}
+	default:
+		index, err := strconv.Atoi(action)
+		if err != nil || index < 0 || index >= len(pick.sheets) {
+			log.Printf("Unknown sheet callback data: %s", query.Data)
+			return
+		}
+		pick.selected[index] = !pick.selected[index]
+
+		edit := tgbotapi.NewEditMessageReplyMarkup(chatID, query.Message.MessageID, pick.keyboard())
+		if _, err := bot.Request(edit); err != nil {
+			log.Printf("Error updating sheet picker: %v", err)
+		}
+		return
+	}
+
+	delete(h.sheetPicks, chatID)
+	h.removeKeyboard(bot, query.Message)
+
+	if h.offerCachedOutput(bot, pick.upload) {
+		return
+	}
+	h.processUpload(bot, pick.upload)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func writeRegionsWorkbook(t *testing.T) string {
+	t.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetSheetName("Sheet1", "Kyiv")
+	f.NewSheet("Lviv")
+	f.NewSheet("Summary")
+	f.SetCellValue("Kyiv", "A1", DefaultTargetText)
+	f.SetCellValue("Kyiv", "B1", "228960453")
+	f.SetCellValue("Kyiv", "C1", "123")
+	f.SetCellValue("Lviv", "A1", DefaultTargetText)
+	f.SetCellValue("Lviv", "B1", "228960454")
+	f.SetCellValue("Lviv", "C1", "456")
+	f.SetCellValue("Summary", "A1", DefaultTargetText)
+	f.SetCellValue("Summary", "B1", "228960453")
+	f.SetCellValue("Summary", "C1", "123")
+
+	path := filepath.Join(t.TempDir(), "regions.xlsx")
+	if err := f.SaveAs(path); err != nil {
+		t.Fatalf("failed to save workbook: %v", err)
+	}
+	return path
+}
+
+func TestReadXlsxFile_SheetFilter(t *testing.T) {
+	h := NewHandler()
+	path := writeRegionsWorkbook(t)
+
+	rule := h.defaultRule()
+	rule.ExcludeSheets = []string{"summary"}
+
+	result, err := h.readXlsxFile(path, rule, "")
+	if err != nil {
+		t.Fatalf("readXlsxFile failed: %v", err)
+	}
+	if len(result.Matches) != 2 {
+		t.Errorf("Expected 2 matches without the summary sheet, got %d", len(result.Matches))
+	}
+	if !reflect.DeepEqual(result.ExcludedSheets, []string{"Summary"}) {
+		t.Errorf("ExcludedSheets = %v", result.ExcludedSheets)
+	}
+	if summary := formatExtractionSummary(result); !strings.Contains(summary, "Sheets not scanned: Summary") {
+		t.Errorf("summary should name the skipped sheet:\n%s", summary)
+	}
+
+	result, err = h.readXlsxFile(path, rule.withSheetSelection([]string{"Lviv"}), "")
+	if err != nil {
+		t.Fatalf("readXlsxFile failed: %v", err)
+	}
+	if len(result.Matches) != 1 || result.Matches[0].Sheet != "Lviv" {
+		t.Errorf("Expected only the Lviv match, got %+v", result.Matches)
+	}
+}
+
+func TestSheetPick(t *testing.T) {
+	sheets, err := workbookSheetNames(writeRegionsWorkbook(t))
+	if err != nil {
+		t.Fatalf("workbookSheetNames failed: %v", err)
+	}
+	if !reflect.DeepEqual(sheets, []string{"Kyiv", "Lviv", "Summary"}) {
+		t.Fatalf("sheets = %v", sheets)
+	}
+
+	pick := &sheetPick{sheets: sheets, selected: []bool{true, false, true}}
+	pick.selected[2] = false
+	if got := pick.selectedSheets(); !reflect.DeepEqual(got, []string{"Kyiv"}) {
+		t.Errorf("selectedSheets() = %v", got)
+	}
+
+	keyboard := pick.keyboard()
+	if len(keyboard.InlineKeyboard) != len(sheets)+1 {
+		t.Fatalf("Expected a row per sheet and one for the actions, got %d rows", len(keyboard.InlineKeyboard))
+	}
+	if button := keyboard.InlineKeyboard[0][0]; button.Text != "✅ Kyiv" || *button.CallbackData != CallbackSheetPrefix+"0" {
+		t.Errorf("unexpected first button: %s / %s", button.Text, *button.CallbackData)
+	}
+
+	if !wantsSheetPicker("csv Sheets") || wantsSheetPicker("sheet") {
+		t.Error("wantsSheetPicker should match the keyword as a whole word")
+	}
+}
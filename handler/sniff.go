@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupportedFormat marks spreadsheets that are recognized but cannot be
+// read, such as binary .xlsb workbooks.
+var ErrUnsupportedFormat = errors.New("spreadsheet format is not supported")
+
+// workbookKind is the container format of an upload as found in its content.
+type workbookKind int
+
+const (
+	kindUnknown workbookKind = iota
+	// kindXLS is a legacy BIFF workbook in an OLE2 compound file.
+	kindXLS
+	// kindOOXML is a ZIP package: .xlsx, .xlsm, .xltx or .xltm.
+	kindOOXML
+	// kindEncrypted is an OOXML package encrypted into an OLE2 container.
+	kindEncrypted
+	// kindXLSB is a binary workbook in a ZIP package.
+	kindXLSB
+)
+
+// workbookExtensions are the file names accepted for upload. Macro-enabled
+// workbooks and templates share the .xlsx layout; their macros are never run,
+// excelize only reads the sheet XML.
+var workbookExtensions = []string{".xls", ".xlsx", ".xlsm", ".xltx", ".xltm"}
+
+// binaryWorkbookExtensions are recognized but not supported.
+var binaryWorkbookExtensions = []string{".xlsb"}
+
+// Main part content types of the OOXML spreadsheet variants.
+var ooxmlWorkbookContentTypes = map[string]workbookKind{
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml":    kindOOXML,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.template.main+xml": kindOOXML,
+	"application/vnd.ms-excel.sheet.macroEnabled.main+xml":                          kindOOXML,
+	"application/vnd.ms-excel.template.macroEnabled.main+xml":                       kindOOXML,
+	"application/vnd.ms-excel.sheet.binary.macroEnabled.main":                       kindXLSB,
+}
+
+func hasExtension(fileName string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, candidate := range extensions {
+		if ext == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffWorkbook tells the workbook kind from the file content, so that the
+// reader does not depend on the name the file was uploaded with. The magic
+// bytes decide the container; readable ZIP packages are further told apart
+// by their content types, which is how .xlsb and non-spreadsheet documents
+// are caught. Packages that cannot be inspected are left to the readers.
+func sniffWorkbook(filePath string) (workbookKind, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return kindUnknown, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(ole2Signature))
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return kindUnknown, fmt.Errorf("failed to read file header: %w", err)
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, ole2Signature):
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return kindUnknown, fmt.Errorf("failed to read file: %w", err)
+		}
+		if bytes.Contains(content, encryptedPackageName) {
+			return kindEncrypted, nil
+		}
+		return kindXLS, nil
+	case bytes.HasPrefix(header, zipSignature):
+		return sniffPackage(filePath), nil
+	}
+
+	return kindUnknown, nil
+}
+
+func sniffPackage(filePath string) workbookKind {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return kindOOXML
+	}
+	defer archive.Close()
+
+	part, err := archive.Open("[Content_Types].xml")
+	if err != nil {
+		return kindOOXML
+	}
+	defer part.Close()
+
+	var types struct {
+		Overrides []struct {
+			ContentType string `xml:"ContentType,attr"`
+		} `xml:"Override"`
+	}
+	if err := xml.NewDecoder(part).Decode(&types); err != nil {
+		return kindOOXML
+	}
+
+	for _, override := range types.Overrides {
+		if kind, ok := ooxmlWorkbookContentTypes[override.ContentType]; ok {
+			return kind
+		}
+	}
+
+	return kindUnknown
+}
+
+// workbookKindOf sniffs the file and falls back to the extension when the
+// content cannot be read, so that the readers report the actual failure.
+func workbookKindOf(filePath string) workbookKind {
+	if kind, err := sniffWorkbook(filePath); err == nil && kind != kindUnknown {
+		return kind
+	}
+	if strings.HasSuffix(strings.ToLower(filePath), ".xls") {
+		return kindXLS
+	}
+	return kindOOXML
+}
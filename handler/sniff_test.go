@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// writeZipPackage writes a ZIP file whose only part is a content types list
+// declaring the given main part content type.
+func writeZipPackage(t *testing.T, name, contentType string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create package: %v", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	part, err := archive.Create("[Content_Types].xml")
+	if err != nil {
+		t.Fatalf("failed to add content types: %v", err)
+	}
+	part.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Override PartName="/main" ContentType="` + contentType + `"/></Types>`))
+	if err := archive.Close(); err != nil {
+		t.Fatalf("failed to write package: %v", err)
+	}
+	return path
+}
+
+func TestSniffWorkbook(t *testing.T) {
+	macroWorkbook := filepath.Join(t.TempDir(), "macros.xlsm")
+	f := excelize.NewFile()
+	if err := f.SaveAs(macroWorkbook); err != nil {
+		t.Fatalf("failed to save workbook: %v", err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name string
+		path string
+		want workbookKind
+	}{
+		{"macro-enabled workbook", macroWorkbook, kindOOXML},
+		{"binary workbook", writeZipPackage(t, "binary.xlsb", "application/vnd.ms-excel.sheet.binary.macroEnabled.main"), kindXLSB},
+		{"binary workbook renamed", writeZipPackage(t, "renamed.xlsx", "application/vnd.ms-excel.sheet.binary.macroEnabled.main"), kindXLSB},
+		{"word document", writeZipPackage(t, "letter.xlsx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"), kindUnknown},
+		{"encrypted workbook", writeEncryptedWorkbook(t, "secret"), kindEncrypted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sniffWorkbook(tt.path)
+			if err != nil {
+				t.Fatalf("sniffWorkbook failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("sniffWorkbook = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySpreadsheetSignature_Binary(t *testing.T) {
+	path := writeZipPackage(t, "binary.xlsb", "application/vnd.ms-excel.sheet.binary.macroEnabled.main")
+
+	err := verifySpreadsheetSignature(path, "report.xlsx")
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got: %v", err)
+	}
+	if text := readErrorText(err); text != TextFileBinaryUnsupported {
+		t.Errorf("readErrorText = %q, want the .xlsb explanation", text)
+	}
+}
+
+func TestReadExcelFile_MacroEnabledTemplate(t *testing.T) {
+	h := NewHandler()
+
+	path := filepath.Join(t.TempDir(), "register.xltm")
+	f := excelize.NewFile()
+	f.SetCellValue("Sheet1", "A1", DefaultTargetText)
+	f.SetCellValue("Sheet1", "B1", "228960453")
+	f.SetCellValue("Sheet1", "C1", "123")
+	if err := f.SaveAs(path); err != nil {
+		t.Fatalf("failed to save template: %v", err)
+	}
+	f.Close()
+
+	result, err := h.readExcelFile(path, h.defaultRule())
+	if err != nil {
+		t.Fatalf("readExcelFile failed: %v", err)
+	}
+	if len(result.Matches) != 1 || result.Matches[0].Number() != "EP-228960453-123" {
+		t.Errorf("unexpected matches: %+v", result.Matches)
+	}
+
+	annotated, err := openAnnotationCopy(path, "")
+	if err != nil {
+		t.Fatalf("openAnnotationCopy failed: %v", err)
+	}
+	defer annotated.Close()
+
+	copyPath := filepath.Join(t.TempDir(), "annotated.xlsx")
+	out, err := os.Create(copyPath)
+	if err != nil {
+		t.Fatalf("failed to create copy: %v", err)
+	}
+	if err := annotated.Write(out); err != nil {
+		t.Fatalf("failed to write copy: %v", err)
+	}
+	out.Close()
+
+	archive, err := zip.OpenReader(copyPath)
+	if err != nil {
+		t.Fatalf("failed to open copy: %v", err)
+	}
+	defer archive.Close()
+
+	part, err := archive.Open("[Content_Types].xml")
+	if err != nil {
+		t.Fatalf("copy has no content types: %v", err)
+	}
+	defer part.Close()
+
+	content, _ := io.ReadAll(part)
+	if !strings.Contains(string(content), "spreadsheetml.sheet.main+xml") {
+		t.Errorf("annotated copy should be a regular workbook, content types: %s", content)
+	}
+}
@@ -3,4 +3,15 @@ package handler
 const (
 	StateDefault = "DEFAULT"
 	StateStart   = "START"
+
+	StateAwaitingDiffBase   = "AWAITING_DIFF_BASE"
+	StateAwaitingDiffTarget = "AWAITING_DIFF_TARGET"
+	StateAwaitingPassword   = "AWAITING_PASSWORD"
+)
+
+const (
+	CallbackDuplicateResend  = "dup_resend"
+	CallbackDuplicateProcess = "dup_process"
+	CallbackFormatPrefix     = "format:"
+	CallbackSheetPrefix      = "sheet:"
 )
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	summaryContractLimit = 10
+	summarySkippedLimit  = 10
+	summarySheetLimit    = 20
+	summaryWarningLimit  = 10
+
+	// telegramMessageLimit is the longest text Telegram accepts in one
+	// message; longer messages are rejected as a whole.
+	telegramMessageLimit = 4096
+)
+
+// formatExtractionSummary describes what was found before the output file is
+// sent: matches per sheet, the first contracts with their cell references,
+// skipped rows and reader warnings. A result without matches gets its own
+// header so the user does not wait for a file that will not come.
+func formatExtractionSummary(result *ExtractionResult) string {
+	var text string
+	if result.Outcome() == OutcomeNoMatches {
+		text = fmt.Sprintf(TextNoMatches, result.TargetText)
+	} else {
+		text = TextSummaryHeader
+		text += fmt.Sprintf(TextSummaryMatches, len(result.Matches))
+	}
+
+	sheetLines := 0
+	for _, sheet := range result.Sheets {
+		if sheet.Matches == 0 && sheet.Skipped == 0 {
+			continue
+		}
+		if sheetLines < summarySheetLimit {
+			text += fmt.Sprintf(TextSummarySheetLine, sheet.Name, sheet.Matches)
+		}
+		sheetLines++
+	}
+	if sheetLines > summarySheetLimit {
+		text += fmt.Sprintf(TextSummaryMore, sheetLines-summarySheetLimit)
+	}
+
+	if len(result.ExcludedSheets) > 0 {
+		text += fmt.Sprintf(TextSummaryExcludedSheets, strings.Join(result.ExcludedSheets, ", "))
+	}
+
+	if result.DuplicatesRemoved > 0 {
+		text += fmt.Sprintf(TextSummaryDuplicates, result.DuplicatesRemoved)
+	}
+
+	if len(result.Matches) > 0 {
+		text += fmt.Sprintf(TextSummaryFirstHeader, min(len(result.Matches), summaryContractLimit))
+		for i, match := range result.Matches {
+			if i == summaryContractLimit {
+				break
+			}
+			text += fmt.Sprintf(TextSummaryContractLine, match.Contract(), match.Sheet, match.Cell)
+		}
+	}
+
+	if len(result.Skipped) > 0 {
+		text += fmt.Sprintf(TextSummarySkippedHeader, len(result.Skipped))
+		for i, skipped := range result.Skipped {
+			if i == summarySkippedLimit {
+				text += fmt.Sprintf(TextSummaryMore, len(result.Skipped)-summarySkippedLimit)
+				break
+			}
+			text += fmt.Sprintf(TextSummarySkippedLine, skipped.Sheet, skipped.Row, skipped.Reason)
+		}
+	}
+
+	if len(result.Warnings) > 0 {
+		text += TextSummaryWarningsHeader
+		for i, warning := range result.Warnings {
+			if i == summaryWarningLimit {
+				text += fmt.Sprintf(TextSummaryMore, len(result.Warnings)-summaryWarningLimit)
+				break
+			}
+			text += "• " + warning + "\n"
+		}
+	}
+
+	return text
+}
+
+// truncateMessage shortens text to what fits in one Telegram message, so a
+// long summary is cut instead of not being delivered at all. Telegram counts
+// UTF-16 code units, in which most emoji take two.
+func truncateMessage(text string) string {
+	if len(utf16.Encode([]rune(text))) <= telegramMessageLimit {
+		return text
+	}
+
+	units := 0
+	for i, r := range text {
+		units += utf16.RuneLen(r)
+		if units > telegramMessageLimit-1 {
+			return text[:i] + "…"
+		}
+	}
+	return text
+}
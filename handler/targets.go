@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"strings"
+)
+
+// How the matches of a rule with several targets are written.
+const (
+	TargetOutputColumn   = "column"
+	TargetOutputSeparate = "separate"
+)
+
+// targetCaptionPrefix starts a caption line naming a target, e.g.
+// "target: Ivanenko I." The caption may hold several such lines.
+const targetCaptionPrefix = "target:"
+
+// requestTargets reads the targets given in a caption, one per line.
+func requestTargets(requestText string) []string {
+	var targets []string
+	for _, line := range strings.Split(requestText, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < len(targetCaptionPrefix) || !strings.EqualFold(line[:len(targetCaptionPrefix)], targetCaptionPrefix) {
+			continue
+		}
+		if target := strings.TrimSpace(line[len(targetCaptionPrefix):]); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// targetGroup holds the matches found by one target.
+type targetGroup struct {
+	Target  string
+	Matches []ContractMatch
+}
+
+func (g targetGroup) Numbers() []string {
+	numbers := make([]string, len(g.Matches))
+	for i, match := range g.Matches {
+		numbers[i] = match.Number()
+	}
+	return numbers
+}
+
+// multiTarget reports whether the result was searched for several targets,
+// so writers have to tell the matches apart.
+func (r *ExtractionResult) multiTarget() bool {
+	return len(r.Rule.targetList()) > 1
+}
+
+// separateTargets reports whether the writers emit one block per target.
+func (r *ExtractionResult) separateTargets() bool {
+	return r.multiTarget() && r.Rule.TargetOutput == TargetOutputSeparate
+}
+
+// targetGroups splits the matches by target in the order the rule lists
+// them, keeping the result order within a group. Targets without matches
+// are left out.
+func (r *ExtractionResult) targetGroups() []targetGroup {
+	var groups []targetGroup
+	for _, target := range r.Rule.targetList() {
+		group := targetGroup{Target: target}
+		for _, match := range r.Matches {
+			if match.Target == target {
+				group.Matches = append(group.Matches, match)
+			}
+		}
+		if len(group.Matches) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
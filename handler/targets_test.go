@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newMultiTargetResult(t *testing.T, targetOutput string) *ExtractionResult {
+	t.Helper()
+
+	rule := Rule{
+		Name:         "agents",
+		Targets:      []string{"Petrenko", "Ivanenko"},
+		TargetOutput: targetOutput,
+		Prefix:       "EP-",
+		Fields:       []string{"series", "number"},
+	}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	result := newExtractionResult("agents.xlsx", rule)
+	result.beginSheet("Sheet1")
+	result.scanRow(0, []string{"Ivanenko I.", "100", "1"})
+	result.scanRow(1, []string{"Petrenko P.", "200", "2"})
+	result.scanRow(2, []string{"Ivanenko I.", "300", "3"})
+	result.scanRow(3, []string{"Sydorenko S.", "400", "4"})
+	return result
+}
+
+func TestMultiTargetScan(t *testing.T) {
+	result := newMultiTargetResult(t, "")
+
+	var targets []string
+	for _, match := range result.Matches {
+		targets = append(targets, match.Target)
+	}
+	if want := []string{"Ivanenko", "Petrenko", "Ivanenko"}; !slices.Equal(targets, want) {
+		t.Errorf("targets = %v, want %v", targets, want)
+	}
+	if result.TargetText != "Petrenko, Ivanenko" {
+		t.Errorf("TargetText = %q", result.TargetText)
+	}
+
+	groups := result.targetGroups()
+	if len(groups) != 2 || groups[0].Target != "Petrenko" || !slices.Equal(groups[1].Numbers(), []string{"EP-100-1", "EP-300-3"}) {
+		t.Errorf("unexpected groups: %+v", groups)
+	}
+}
+
+func TestMultiTargetOutput(t *testing.T) {
+	t.Run("column", func(t *testing.T) {
+		result := newMultiTargetResult(t, TargetOutputColumn)
+
+		var sql bytes.Buffer
+		if err := (SQLWriter{}).Write(&sql, result); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		script := sql.String()
+		for _, want := range []string{"sort_order.[target] AS 'Ціль пошуку'", "('EP-100-1', 0, N'Ivanenko')", "AS sort_order(number, sort_seq, [target])"} {
+			if !strings.Contains(script, want) {
+				t.Errorf("script is missing %q:\n%s", want, script)
+			}
+		}
+
+		var csv bytes.Buffer
+		if err := (CSVWriter{}).Write(&csv, result); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if !strings.Contains(csv.String(), "number,sheet,row,cell,target") || !strings.Contains(csv.String(), "EP-200-2,Sheet1,2,A2,Petrenko") {
+			t.Errorf("unexpected CSV:\n%s", csv.String())
+		}
+	})
+
+	t.Run("separate", func(t *testing.T) {
+		result := newMultiTargetResult(t, TargetOutputSeparate)
+
+		var sql bytes.Buffer
+		if err := (SQLWriter{}).Write(&sql, result); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		script := sql.String()
+		if strings.Count(script, "ORDER BY") != 2 || !strings.HasPrefix(script, "-- Petrenko\n") || !strings.Contains(script, "-- Ivanenko\n") {
+			t.Errorf("expected one script per target:\n%s", script)
+		}
+		if strings.Contains(script, "sort_order.[target]") {
+			t.Errorf("separate scripts should not carry a target column:\n%s", script)
+		}
+
+		var list bytes.Buffer
+		if err := (ListWriter{}).Write(&list, result); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if want := "# Petrenko\nEP-200-2\n\n# Ivanenko\nEP-100-1\nEP-300-3\n"; list.String() != want {
+			t.Errorf("list = %q, want %q", list.String(), want)
+		}
+	})
+
+	t.Run("single target keeps the plain layout", func(t *testing.T) {
+		result := newExtractionResult("test.xlsx", builtinRule(""))
+		result.beginSheet("Sheet1")
+		result.scanRow(0, []string{DefaultTargetText, "228960453", "123"})
+
+		if columns := outputColumns(result); slices.Contains(columns, "target") {
+			t.Errorf("unexpected target column: %v", columns)
+		}
+		if len(result.sqlColumns()) != 0 {
+			t.Error("single target should not add SQL columns")
+		}
+	})
+}
+
+func TestRequestTargets(t *testing.T) {
+	caption := "csv\nTarget: Ivanenko I.\ntarget:  Petrenko \ntarget:\nnot a target: x"
+	if got, want := requestTargets(caption), []string{"Ivanenko I.", "Petrenko"}; !slices.Equal(got, want) {
+		t.Errorf("requestTargets() = %v, want %v", got, want)
+	}
+
+	rule := builtinRule("")
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	override := rule.withTargets([]string{"Ivanenko", "Petrenko"})
+	if target, found := override.matchTarget("agent Petrenko"); !found || target != "Petrenko" {
+		t.Errorf("matchTarget() = %q, %v", target, found)
+	}
+	if _, found := override.matchTarget(DefaultTargetText); found {
+		t.Error("the overridden target text should no longer match")
+	}
+}
@@ -1,5 +1,10 @@
 package handler
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	TextUnknownCommand = "Unknown command. Use /start to begin."
 
@@ -7,9 +12,12 @@ const (
 	TextWelcome         = "Welcome to the XLS File Reader Bot!\n"
 	TextWelcomeDesc     = "I'm here to help you process Excel files.\n\n"
 	TextFunctionsHeader = "📋 Available functions:\n"
-	TextFunction1       = "• Send me an Excel file (.xls, .xlsx) to read and process\n"
+	TextFunction1       = "• Send me an Excel file (.xls, .xlsx) or a .zip of them to read and process\n"
 	TextFunction2       = "• I will extract and display the data for you\n"
-	TextFunction3       = "• Use /start to see this message again"
+	TextFunction3       = "• Use /history to see your recent uploads and /rerun <id> to process one again\n"
+	TextFunction4       = "• Use /diff to compare two registers and get a script for the new contracts\n"
+	TextFunction5       = "• Use /format to choose the output format (%s)\n"
+	TextFunction6       = "• Use /start to see this message again"
 
 	TextInstructionsHeader = "📖 Bot Instructions\n\n"
 	TextInstructionsDesc   = "This bot helps you read and process Excel files.\n\n"
@@ -19,18 +27,99 @@ const (
 	TextInstructionsFunc3  = "• Data display - View your Excel data in a readable format\n\n"
 	TextInstructionsTip    = "💡 To get started, use /start command or simply send me an Excel file!"
 
-	TextFileReceived      = "✅ File received successfully!\n\n"
-	TextFileName          = "📄 File name: %s\n"
-	TextFileSize          = "📊 File size: %.2f KB\n"
-	TextFileProcessing    = "Processing your Excel file..."
-	TextFileInvalidType   = "❌ Invalid file type!\n\nPlease send an Excel file (.xls or .xlsx format)."
-	TextFileDownloadError = "❌ Error downloading file. Please try again."
-	TextFileSaveError     = "❌ Error saving file. Please try again."
-	TextFileReadError     = "❌ Error reading Excel file. Please make sure it's a valid Excel file."
-	TextFileProcessed     = "✅ File processed successfully!\n\nHere is the extracted content:"
+	TextFileReceived          = "✅ File received successfully!\n\n"
+	TextFileName              = "📄 File name: %s\n"
+	TextFileSize              = "📊 File size: %.2f KB\n"
+	TextFileProcessing        = "Processing your Excel file..."
+	TextFileInvalidType       = "❌ Invalid file type!\n\nPlease send an Excel file (.xls, .xlsx, .xlsm, .xltx or .xltm format)."
+	TextFileBinaryUnsupported = "❌ Binary Excel workbooks (.xlsb) are not supported.\n\nPlease open the file in Excel and save it as .xlsx (File → Save As → Excel Workbook), then send it again."
+	TextFileDownloadError     = "❌ Error downloading file. Please try again."
+	TextFileSaveError         = "❌ Error saving file. Please try again."
+	TextFileTooLarge          = "❌ File is too large!\n\nThe maximum supported size is %d MB."
+	TextFileInvalidContent    = "❌ File content is not a valid Excel workbook.\n\nPlease make sure the file was not renamed or damaged."
+	TextFileDownloadTimeout   = "❌ Downloading the file took too long. Please try again."
+	TextFileReadError         = "❌ Error reading Excel file. Please make sure it's a valid Excel file."
+	TextFileProcessed         = "✅ File processed successfully!\n\nHere is the extracted content:"
+
+	TextBatchFileLine         = "• %s: %d contracts\n"
+	TextBatchFileFailed       = "• %s: ❌ %s\n"
+	TextBatchReasonEncrypted  = "password-protected, send it separately"
+	TextBatchReasonBinary     = ".xlsb is not supported"
+	TextBatchReasonInvalid    = "not a valid Excel workbook"
+	TextBatchReasonUnreadable = "could not be read"
+
+	TextArchiveHeader    = "📦 Archive with %d spreadsheets\n\n"
+	TextArchiveEmpty     = "📦 The archive contains no Excel files."
+	TextArchiveLimit     = "❌ The archive was rejected: %v."
+	TextArchiveReadError = "❌ Error reading the archive. Please make sure it's a valid .zip file."
+
+	TextMediaGroupCollecting = "🗂 Collecting the files sent together…"
+	TextMediaGroupHeader     = "🗂 %d files sent together\n\n"
+
+	TextSheetPickerHeader  = "📑 %s has %d sheets.\n\nTap sheets to select or deselect them, then press Process."
+	TextSheetProcessButton = "▶️ Process selected"
+	TextSheetAllButton     = "📚 All sheets"
+	TextSheetNoneSelected  = "Please select at least one sheet."
+	TextSheetPickExpired   = "This sheet selection has expired. Please send the file again."
+
+	TextSummaryHeader         = "📊 Extraction summary\n\n"
+	TextSummaryMatches        = "Matched rows: %d\n"
+	TextSummarySheetLine      = "• %s: %d\n"
+	TextSummaryFirstHeader    = "\nFirst %d contracts:\n"
+	TextSummaryContractLine   = "• %s (%s!%s)\n"
+	TextSummarySkippedHeader  = "\n⚠️ Skipped rows: %d\n"
+	TextSummarySkippedLine    = "• %s, row %d: %s\n"
+	TextSummaryMore           = "… and %d more\n"
+	TextSummaryWarningsHeader = "\n⚠️ Warnings:\n"
+	TextSummaryExcludedSheets = "Sheets not scanned: %s\n"
+	TextSummaryDuplicates     = "♻️ Duplicates removed: %d\n"
+	TextNoMatches             = "🔍 No matching data found for '%s'.\n\nNo script was generated. Please check that the file contains the expected rows.\n"
+
+	TextHistoryEmpty     = "🗂 You have no processed uploads yet. Send me an Excel file to get started."
+	TextHistoryHeader    = "🗂 Your recent uploads:\n\n"
+	TextHistoryItem      = "#%d • %s • %s • %d contracts\n"
+	TextHistoryFooter    = "\nUse /rerun <id> to process a file again."
+	TextRerunUsage       = "Usage: /rerun <id> [format]\n\nUse /history to find the id of a previous upload."
+	TextRerunNotFound    = "❌ Upload #%d was not found in your history."
+	TextRerunFileMissing = "❌ The stored copy of %s is no longer available. Please send the file again."
+	TextRerunProcessing  = "🔁 Re-running upload #%d (%s)..."
+
+	TextDuplicateDetected        = "♻️ This file was already processed on %s by %s (%s, %d contracts).\n\nDo you want the previous result or process it again?"
+	TextDuplicateResendButton    = "📎 Resend previous result"
+	TextDuplicateProcessButton   = "🔄 Process again"
+	TextDuplicateExpired         = "This choice has expired. Please send the file again."
+	TextDuplicateProcessedBefore = "♻️ This file was already processed on %s by %s (%s, %d contracts). Its result does not fit this request, so the file is processed again."
+	TextCachedOutputSent         = "✅ Previous result for this file:"
+
+	TextDiffSendBase      = "🆚 Register comparison\n\nSend me the previous (base) register first."
+	TextDiffSendTarget    = "📥 Base register: %s (%d contracts).\n\nNow send the updated register to compare."
+	TextDiffUsage         = "Usage: /diff or /diff <id>\n\n/diff asks for two files, /diff <id> compares the next file against an upload from /history."
+	TextDiffAwaitingFile  = "📎 Please send an Excel file to continue the comparison, or /start to cancel."
+	TextDiffHeader        = "🆚 Register comparison\n\n"
+	TextDiffBaseLine      = "Base: %s (%d contracts)\n"
+	TextDiffTargetLine    = "New: %s (%d contracts)\n\n"
+	TextDiffCounts        = "➕ Added: %d\n➖ Removed: %d\n✔️ Unchanged: %d\n"
+	TextDiffAddedHeader   = "\n➕ Added contracts:\n"
+	TextDiffRemovedHeader = "\n➖ Removed contracts:\n"
+	TextDiffMore          = "… and %d more\n"
+	TextDiffReportCaption = "📄 Full comparison report"
+	TextDiffScriptCaption = "✅ SQL script for the added contracts:"
+
+	TextFormatCurrent   = "🧾 Your default output format: %s\n\nAvailable formats: %s\nPick a new default below, or add it to the file caption (e.g. \"format=csv\") to use it once."
+	TextFormatSaved     = "✅ Default output format set to %s."
+	TextFormatSaveError = "❌ Could not save your default format. Please try again."
+
+	TextOutputError  = "❌ Error preparing the output file. Please try again."
+	TextQueryFailed  = "❌ The query could not be executed against the database."
+	TextQueryTimeout = "❌ The query took too long and was cancelled."
+
+	TextPasswordRequired         = "🔒 This workbook is password-protected. Please send the password as a message; it will be deleted from the chat right away and never stored."
+	TextPasswordIncorrect        = "❌ Wrong password. Please try again (%d attempts left)."
+	TextPasswordAttemptsExceeded = "❌ Wrong password. The file was not processed; send it again to retry."
+	TextWorkbookEncrypted        = "🔒 This workbook is password-protected. Send it as a regular upload to enter the password."
 )
 
-func GetWelcomeText(username string) string {
+func GetWelcomeText(username string, formats []string) string {
 	if username == "" {
 		username = "there"
 	}
@@ -42,6 +131,9 @@ func GetWelcomeText(username string) string {
 	text += TextFunction1
 	text += TextFunction2
 	text += TextFunction3
+	text += TextFunction4
+	text += fmt.Sprintf(TextFunction5, strings.Join(formats, ", "))
+	text += TextFunction6
 
 	return text
 }
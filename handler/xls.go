@@ -0,0 +1,395 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/extrame/xls"
+	"github.com/richardlehane/mscfb"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	xlsDateLayout     = "2006-01-02"
+	xlsDateTimeLayout = "2006-01-02 15:04:05"
+
+	// firstCustomFormat is the lowest number format id a workbook defines
+	// itself; lower ids are Excel's built-in formats.
+	firstCustomFormat = 164
+)
+
+// BIFF record ids the typed reader looks at.
+const (
+	biffBOF        = 0x0809
+	biffEOF        = 0x000A
+	biffBoundSheet = 0x0085
+	biffDateMode   = 0x0022
+	biffFormat     = 0x041E
+	biffXF         = 0x00E0
+	biffNumber     = 0x0203
+	biffRK         = 0x027E
+	biffMulRK      = 0x00BD
+	biffFormula    = 0x0006
+	biffString     = 0x0207
+	biffSharedFmla = 0x04BC
+	biffArray      = 0x0221
+	biffTable      = 0x0236
+
+	biff8Version = 0x0600
+)
+
+// xlsErrorValues are the texts of formula error codes.
+var xlsErrorValues = map[byte]string{
+	0x00: "#NULL!",
+	0x07: "#DIV/0!",
+	0x0F: "#VALUE!",
+	0x17: "#REF!",
+	0x1D: "#NAME?",
+	0x24: "#NUM!",
+	0x2A: "#N/A",
+}
+
+// xlsCellReader reads .xls cells with their types. extrame/xls only exposes
+// cells as text, which shows numbers with custom formats as broken dates,
+// shortens dates to year and month and replaces formula results with
+// "FormulaCol"; it also drops the text results of formulas. The numeric
+// records, formula results, formats and the date system are therefore read
+// from the workbook stream itself, and everything else keeps the library's
+// text.
+type xlsCellReader struct {
+	stream       []byte
+	biff8        bool
+	date1904     bool
+	formats      map[uint16]string
+	xfFormats    []uint16
+	sheetOffsets []uint32
+}
+
+// xlsSheetCells are the typed values of one sheet by row and column.
+type xlsSheetCells map[uint16]map[uint16]string
+
+func newXlsCellReader(filePath string) (*xlsCellReader, error) {
+	stream, err := readXlsStream(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &xlsCellReader{stream: stream, formats: make(map[uint16]string)}
+	reader.readGlobals()
+	return reader, nil
+}
+
+// readXlsStream returns the BIFF stream of an .xls file: "Workbook" in
+// BIFF8 files, "Book" in older ones.
+func readXlsStream(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	doc, err := mscfb.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read XLS container: %w", err)
+	}
+
+	for entry, err := doc.Next(); err == nil; entry, err = doc.Next() {
+		if entry.Name == "Workbook" || entry.Name == "Book" {
+			return io.ReadAll(entry)
+		}
+	}
+	return nil, errors.New("XLS file has no workbook stream")
+}
+
+// records calls fn for each BIFF record from offset on until fn returns
+// false or the stream ends.
+func (c *xlsCellReader) records(offset uint32, fn func(id uint16, data []byte) bool) {
+	for pos := int(offset); pos+4 <= len(c.stream); {
+		id := binary.LittleEndian.Uint16(c.stream[pos:])
+		size := int(binary.LittleEndian.Uint16(c.stream[pos+2:]))
+		if pos+4+size > len(c.stream) {
+			return
+		}
+		if !fn(id, c.stream[pos+4:pos+4+size]) {
+			return
+		}
+		pos += 4 + size
+	}
+}
+
+// readGlobals reads the workbook globals: the BIFF version, the date
+// system, number formats, cell styles and where each sheet starts.
+func (c *xlsCellReader) readGlobals() {
+	c.records(0, func(id uint16, data []byte) bool {
+		switch {
+		case id == biffEOF:
+			return false
+		case id == biffBOF && len(data) >= 2:
+			c.biff8 = binary.LittleEndian.Uint16(data) == biff8Version
+		case id == biffDateMode && len(data) >= 2:
+			c.date1904 = binary.LittleEndian.Uint16(data) == 1
+		case id == biffFormat && len(data) >= 3:
+			c.formats[binary.LittleEndian.Uint16(data)] = c.decodeString(data[2:])
+		case id == biffXF && len(data) >= 4:
+			c.xfFormats = append(c.xfFormats, binary.LittleEndian.Uint16(data[2:]))
+		case id == biffBoundSheet && len(data) >= 4:
+			c.sheetOffsets = append(c.sheetOffsets, binary.LittleEndian.Uint32(data))
+		}
+		return true
+	})
+}
+
+// sheetCells reads the numbers, dates and formula results of the sheet at
+// index, in the order extrame/xls lists the sheets.
+func (c *xlsCellReader) sheetCells(index int) xlsSheetCells {
+	cells := make(xlsSheetCells)
+	if index < 0 || index >= len(c.sheetOffsets) {
+		return cells
+	}
+
+	set := func(row, col uint16, value string) {
+		if cells[row] == nil {
+			cells[row] = make(map[uint16]string)
+		}
+		cells[row][col] = value
+	}
+
+	// A formula with a text result is followed by a STRING record holding
+	// the text, possibly after its shared formula or array record.
+	var pendingRow, pendingCol uint16
+	pendingText := false
+
+	first := true
+	c.records(c.sheetOffsets[index], func(id uint16, data []byte) bool {
+		if first {
+			first = false
+			return id == biffBOF
+		}
+
+		switch {
+		case id == biffEOF:
+			return false
+		case id == biffString:
+			if pendingText {
+				set(pendingRow, pendingCol, c.decodeTextResult(data))
+			}
+			pendingText = false
+			return true
+		case id == biffSharedFmla || id == biffArray || id == biffTable:
+			return true
+		}
+		pendingText = false
+
+		if len(data) < 6 {
+			return true
+		}
+		row := binary.LittleEndian.Uint16(data)
+		col := binary.LittleEndian.Uint16(data[2:])
+
+		switch {
+		case id == biffNumber && len(data) >= 14:
+			value := math.Float64frombits(binary.LittleEndian.Uint64(data[6:]))
+			set(row, col, c.formatNumber(value, uint64(binary.LittleEndian.Uint16(data[4:]))))
+		case id == biffRK && len(data) >= 10:
+			value := decodeRK(binary.LittleEndian.Uint32(data[6:]))
+			set(row, col, c.formatNumber(value, uint64(binary.LittleEndian.Uint16(data[4:]))))
+		case id == biffMulRK:
+			for i, entry := 0, data[4:len(data)-2]; len(entry) >= 6; i, entry = i+1, entry[6:] {
+				value := decodeRK(binary.LittleEndian.Uint32(entry[2:]))
+				set(row, col+uint16(i), c.formatNumber(value, uint64(binary.LittleEndian.Uint16(entry))))
+			}
+		case id == biffFormula && len(data) >= 14:
+			result := data[6:14]
+			// Text, boolean, error and empty results are flagged by 0xFFFF
+			// in the last two bytes; anything else is the cached number.
+			if result[6] != 0xFF || result[7] != 0xFF {
+				value := math.Float64frombits(binary.LittleEndian.Uint64(result))
+				set(row, col, c.formatNumber(value, uint64(binary.LittleEndian.Uint16(data[4:]))))
+				break
+			}
+
+			switch result[0] {
+			case 0:
+				set(row, col, "")
+				pendingRow, pendingCol, pendingText = row, col, true
+			case 1:
+				set(row, col, strings.ToUpper(strconv.FormatBool(result[2] != 0)))
+			case 2:
+				set(row, col, xlsErrorValues[result[2]])
+			default:
+				set(row, col, "")
+			}
+		}
+		return true
+	})
+
+	return cells
+}
+
+// decodeTextResult reads the text of a STRING record.
+func (c *xlsCellReader) decodeTextResult(data []byte) string {
+	if c.biff8 {
+		return c.decodeString(data)
+	}
+	if len(data) < 2 {
+		return ""
+	}
+	return decodeByteString(data[2:], int(binary.LittleEndian.Uint16(data)))
+}
+
+// decodeString reads a BIFF string with a 16-bit length in BIFF8 and an
+// 8-bit one in older versions. BIFF8 strings are either UTF-16LE or, when
+// compressed, one byte per character.
+func (c *xlsCellReader) decodeString(data []byte) string {
+	if !c.biff8 {
+		return decodeByteString(data[1:], int(data[0]))
+	}
+	if len(data) < 3 {
+		return ""
+	}
+
+	length := int(binary.LittleEndian.Uint16(data))
+	flags := data[2]
+	chars := data[3:]
+	if flags&0x08 != 0 {
+		chars = chars[min(2, len(chars)):]
+	}
+	if flags&0x04 != 0 {
+		chars = chars[min(4, len(chars)):]
+	}
+	if flags&0x01 == 0 {
+		return decodeByteString(chars, length)
+	}
+
+	units := make([]uint16, 0, length)
+	for i := 0; i+1 < len(chars) && len(units) < length; i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(chars[i:]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeByteString(chars []byte, length int) string {
+	runes := make([]rune, 0, length)
+	for _, b := range chars[:min(length, len(chars))] {
+		runes = append(runes, rune(b))
+	}
+	return string(runes)
+}
+
+// rowValues returns the row's values starting from column 0: the typed
+// values of the sheet where it has them, the library's text elsewhere.
+func (cells xlsSheetCells) rowValues(rowIndex int, row *xls.Row) []string {
+	typed := cells[uint16(rowIndex)]
+
+	width := row.LastCol()
+	for col := range typed {
+		width = max(width, int(col)+1)
+	}
+
+	values := make([]string, width)
+	for colIndex := row.FirstCol(); colIndex < row.LastCol(); colIndex++ {
+		values[colIndex] = row.Col(colIndex)
+	}
+	for col, value := range typed {
+		values[col] = value
+	}
+	return values
+}
+
+// formatNumber renders a numeric cell: dates in ISO form using the
+// workbook's date system, whole numbers without a fraction or exponent.
+func (c *xlsCellReader) formatNumber(value float64, xfIndex uint64) string {
+	if c.isDateFormat(c.formatID(xfIndex)) {
+		if date, err := excelize.ExcelDateToTime(value, c.date1904); err == nil {
+			if value == math.Trunc(value) {
+				return date.Format(xlsDateLayout)
+			}
+			return date.Format(xlsDateTimeLayout)
+		}
+	}
+
+	return formatXlsNumber(value)
+}
+
+func formatXlsNumber(value float64) string {
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (c *xlsCellReader) formatID(xfIndex uint64) uint16 {
+	if xfIndex >= uint64(len(c.xfFormats)) {
+		return 0
+	}
+	return c.xfFormats[xfIndex]
+}
+
+func (c *xlsCellReader) isDateFormat(formatID uint16) bool {
+	if formatID < firstCustomFormat {
+		return isBuiltinDateFormat(formatID)
+	}
+
+	code, found := c.formats[formatID]
+	return found && isDateFormatCode(code)
+}
+
+// isBuiltinDateFormat covers Excel's built-in date and time formats,
+// including the East Asian ones.
+func isBuiltinDateFormat(formatID uint16) bool {
+	return formatID >= 14 && formatID <= 22 ||
+		formatID >= 27 && formatID <= 36 ||
+		formatID >= 45 && formatID <= 47 ||
+		formatID >= 50 && formatID <= 58
+}
+
+// isDateFormatCode reports whether a custom number format shows a date or
+// time, ignoring quoted text, escaped characters and [color] sections.
+func isDateFormatCode(code string) bool {
+	var visible strings.Builder
+	inQuotes, inBrackets, escaped := false, false, false
+
+	for _, r := range code {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case r == '[':
+			inBrackets = true
+		case r == ']':
+			inBrackets = false
+		case inBrackets:
+		default:
+			visible.WriteRune(r)
+		}
+	}
+
+	return strings.ContainsAny(strings.ToLower(visible.String()), "dmyhs")
+}
+
+// decodeRK unpacks Excel's compressed RK number: either a signed 30-bit
+// integer or the upper 30 bits of a float, optionally multiplied by 100.
+func decodeRK(rk uint32) float64 {
+	var value float64
+	if rk&2 != 0 {
+		value = float64(int32(rk) >> 2)
+	} else {
+		value = math.Float64frombits(uint64(rk&^3) << 32)
+	}
+
+	if rk&1 != 0 {
+		value /= 100
+	}
+	return value
+}
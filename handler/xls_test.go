@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/extrame/xls"
+)
+
+func TestDecodeRK(t *testing.T) {
+	tests := []struct {
+		name string
+		rk   uint32
+		want float64
+	}{
+		{"integer", 228960453<<2 | 2, 228960453},
+		{"negative integer", 0xFFFFFFEE, -5},
+		{"integer divided by 100", 12345<<2 | 3, 123.45},
+		{"float", uint32(math.Float64bits(1.5) >> 32), 1.5},
+	}
+
+	for _, tt := range tests {
+		if got := decodeRK(tt.rk); got != tt.want {
+			t.Errorf("%s: decodeRK(%#x) = %v, want %v", tt.name, tt.rk, got, tt.want)
+		}
+	}
+}
+
+func TestXlsFormatNumber(t *testing.T) {
+	reader := &xlsCellReader{xfFormats: []uint16{0, 14, 22}}
+
+	tests := []struct {
+		name  string
+		value float64
+		xf    uint64
+		want  string
+	}{
+		{"large integer", 228960453, 0, "228960453"},
+		{"fraction", 12.5, 0, "12.5"},
+		{"date", 45292, 1, "2024-01-01"},
+		{"date and time", 45292.5, 2, "2024-01-01 12:00:00"},
+		{"unknown style", 7, 99, "7"},
+	}
+
+	for _, tt := range tests {
+		if got := reader.formatNumber(tt.value, tt.xf); got != tt.want {
+			t.Errorf("%s: formatNumber(%v, %d) = %q, want %q", tt.name, tt.value, tt.xf, got, tt.want)
+		}
+	}
+
+	reader.date1904 = true
+	if got := reader.formatNumber(0, 1); got != "1904-01-01" {
+		t.Errorf("1904 date system: got %q, want %q", got, "1904-01-01")
+	}
+}
+
+func TestIsDateFormatCode(t *testing.T) {
+	tests := map[string]bool{
+		"dd.mm.yyyy":            true,
+		"[$-422]d mmmm yyyy":    true,
+		"hh:mm":                 true,
+		"General":               false,
+		"000000000":             false,
+		`0 "днів"`:              false,
+		`#,##0.00\ [$₴-422]`:    false,
+		"[Red]0.00;[Blue]-0.00": false,
+	}
+
+	for code, want := range tests {
+		if got := isDateFormatCode(code); got != want {
+			t.Errorf("isDateFormatCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestXlsSheetCells(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "typed.xls")
+	writeTestXls(t, path)
+
+	wb, err := xls.Open(path, "utf-8")
+	if err != nil {
+		t.Fatalf("xls.Open: %v", err)
+	}
+	reader, err := newXlsCellReader(path)
+	if err != nil {
+		t.Fatalf("newXlsCellReader: %v", err)
+	}
+
+	sheet := wb.GetSheet(0)
+	if sheet == nil || sheet.Row(0) == nil {
+		t.Fatal("extrame/xls did not read the test sheet")
+	}
+
+	got := reader.sheetCells(0).rowValues(0, sheet.Row(0))
+	want := []string{"EP-1", "228960453", "2024-01-01", "7", "8", "12.5", "EP-42", "TRUE", "#DIV/0!"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rowValues = %q, want %q", got, want)
+	}
+}
+
+// writeTestXls writes a one-sheet BIFF8 workbook with a text cell, a
+// number, a date with a custom format, a MULRK pair and formulas with
+// number, text, boolean and error results.
+func writeTestXls(t *testing.T, path string) {
+	t.Helper()
+
+	record := func(id uint16, parts ...any) []byte {
+		var data bytes.Buffer
+		for _, part := range parts {
+			binary.Write(&data, binary.LittleEndian, part)
+		}
+		header := binary.LittleEndian.AppendUint16(nil, id)
+		header = binary.LittleEndian.AppendUint16(header, uint16(data.Len()))
+		return append(header, data.Bytes()...)
+	}
+	text := func(s string) []any { return []any{uint16(len(s)), byte(0), []byte(s)} }
+	bof := func(kind uint16) []byte {
+		return record(biffBOF, uint16(biff8Version), kind, uint16(0), uint16(0), uint32(0), uint32(0))
+	}
+	xf := func(format uint16) []byte { return record(biffXF, uint16(0), format, make([]byte, 16)) }
+	formula := func(col uint16, result [8]byte) []byte {
+		return record(biffFormula, uint16(0), col, uint16(0), result, uint16(0), uint32(0), uint16(3), byte(0x1E), uint16(1))
+	}
+
+	globals := [][]byte{
+		bof(0x0005),
+		record(biffFormat, append([]any{uint16(164)}, text("dd.mm.yyyy")...)...),
+		xf(0), xf(164),
+		nil, // BOUNDSHEET, once the sheet offset is known
+		record(0x00FC, append([]any{uint32(1), uint32(1)}, text("EP-1")...)...),
+		record(biffEOF),
+	}
+	sheetName := "Data"
+	boundSheet := func(offset uint32) []byte {
+		return record(biffBoundSheet, offset, byte(0), byte(0), byte(len(sheetName)), byte(0), []byte(sheetName))
+	}
+	globals[4] = boundSheet(0)
+
+	sheet := [][]byte{
+		bof(0x0010),
+		record(0x0208, uint16(0), uint16(0), uint16(9), uint16(0), uint16(0), uint16(0), uint32(0)),
+		record(0x00FD, uint16(0), uint16(0), uint16(0), uint32(0)),
+		record(biffNumber, uint16(0), uint16(1), uint16(0), float64(228960453)),
+		record(biffRK, uint16(0), uint16(2), uint16(1), uint32(45292<<2|2)),
+		record(biffMulRK, uint16(0), uint16(3), uint16(0), uint32(7<<2|2), uint16(0), uint32(8<<2|2), uint16(4)),
+		formula(5, [8]byte{0, 0, 0, 0, 0, 0, 0x29, 0x40}),
+		formula(6, [8]byte{0, 0, 0, 0, 0, 0, 0xFF, 0xFF}),
+		record(biffString, text("EP-42")...),
+		formula(7, [8]byte{1, 0, 1, 0, 0, 0, 0xFF, 0xFF}),
+		formula(8, [8]byte{2, 0, 0x07, 0, 0, 0, 0xFF, 0xFF}),
+		record(biffEOF),
+	}
+
+	globals[4] = boundSheet(uint32(len(bytes.Join(globals, nil))))
+	stream := append(bytes.Join(globals, nil), bytes.Join(sheet, nil)...)
+
+	if err := os.WriteFile(path, compoundFile("Workbook", stream), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compoundFile wraps one stream in a minimal OLE2 container: a header, one
+// FAT sector, one directory sector and the stream, padded past the 4096
+// byte mini stream cutoff so it lives in regular sectors.
+func compoundFile(name string, stream []byte) []byte {
+	const (
+		sectorSize = 512
+		endOfChain = 0xFFFFFFFE
+		freeSector = 0xFFFFFFFF
+		noStream   = 0xFFFFFFFF
+	)
+
+	stream = append(stream, make([]byte, max(0, 4096-len(stream)))...)
+	stream = append(stream, make([]byte, (sectorSize-len(stream)%sectorSize)%sectorSize)...)
+	streamSectors := len(stream) / sectorSize
+
+	header := make([]byte, sectorSize)
+	copy(header, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1})
+	le := binary.LittleEndian
+	le.PutUint16(header[0x18:], 0x003E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], 1)
+	le.PutUint32(header[0x30:], 1)
+	le.PutUint32(header[0x38:], 4096)
+	le.PutUint32(header[0x3C:], endOfChain)
+	le.PutUint32(header[0x44:], endOfChain)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(header[0x4C+4*i:], freeSector)
+	}
+	le.PutUint32(header[0x4C:], 0)
+
+	fat := make([]byte, sectorSize)
+	for i := 0; i < sectorSize/4; i++ {
+		le.PutUint32(fat[4*i:], freeSector)
+	}
+	le.PutUint32(fat[0:], 0xFFFFFFFD)
+	le.PutUint32(fat[4:], endOfChain)
+	for i := 0; i < streamSectors; i++ {
+		next := uint32(3 + i)
+		if i == streamSectors-1 {
+			next = endOfChain
+		}
+		le.PutUint32(fat[4*(2+i):], next)
+	}
+
+	dir := make([]byte, sectorSize)
+	entry := func(index int, entryName string, kind byte, child, start uint32, size uint64) {
+		e := dir[index*128 : (index+1)*128]
+		units := utf16.Encode([]rune(entryName))
+		for i, unit := range units {
+			le.PutUint16(e[2*i:], unit)
+		}
+		if entryName != "" {
+			le.PutUint16(e[0x40:], uint16(2*len(units)+2))
+		}
+		e[0x42] = kind
+		e[0x43] = 1
+		le.PutUint32(e[0x44:], noStream)
+		le.PutUint32(e[0x48:], noStream)
+		le.PutUint32(e[0x4C:], child)
+		le.PutUint32(e[0x74:], start)
+		le.PutUint64(e[0x78:], size)
+	}
+	entry(0, "Root Entry", 5, 1, endOfChain, 0)
+	entry(1, name, 2, noStream, 2, uint64(len(stream)))
+	entry(2, "", 0, noStream, 0, 0)
+	entry(3, "", 0, noStream, 0, 0)
+
+	return bytes.Join([][]byte{header, fat, dir, stream}, nil)
+}
@@ -0,0 +1,380 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSheetLayout is what has to be known about a sheet before its rows are
+// streamed: merged regions and the cells that hold formulas. excelize only
+// offers these through the fully parsed worksheet, which costs far more
+// memory than the rows themselves, so they are collected by scanning the
+// sheet XML once.
+type xlsxSheetLayout struct {
+	merges   []mergedRange
+	formulas []string
+}
+
+type mergedRange struct {
+	startCol, startRow int
+	endCol, endRow     int
+	value              string
+}
+
+// xlsxPackage gives access to the raw parts of an .xlsx file.
+type xlsxPackage struct {
+	archive    *zip.Reader
+	closer     io.Closer
+	sheetPaths map[string]string
+	path       string
+	password   string
+}
+
+// openXlsxPackage opens the ZIP package of a workbook. Encrypted workbooks
+// are decrypted into memory, as excelize does when opening them.
+func openXlsxPackage(filePath, password string) (*xlsxPackage, error) {
+	pkg := &xlsxPackage{path: filePath, password: password}
+
+	if password == "" {
+		archive, err := zip.OpenReader(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open workbook package: %w", err)
+		}
+		pkg.archive, pkg.closer = &archive.Reader, archive
+	} else {
+		raw, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read workbook: %w", err)
+		}
+		decrypted, err := excelize.Decrypt(raw, &excelize.Options{Password: password})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt workbook package: %w", err)
+		}
+		pkg.archive, err = zip.NewReader(bytes.NewReader(decrypted), int64(len(decrypted)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open workbook package: %w", err)
+		}
+	}
+
+	if err := pkg.readSheetPaths(); err != nil {
+		pkg.Close()
+		return nil, err
+	}
+
+	return pkg, nil
+}
+
+func (p *xlsxPackage) Close() error {
+	if p.closer == nil {
+		return nil
+	}
+	return p.closer.Close()
+}
+
+// readSheetPaths maps sheet names to their XML parts through the workbook
+// relationships.
+func (p *xlsxPackage) readSheetPaths() error {
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := p.decodePart("xl/workbook.xml", &workbook); err != nil {
+		return err
+	}
+
+	var relationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := p.decodePart("xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return err
+	}
+
+	targets := make(map[string]string, len(relationships.Relationships))
+	for _, rel := range relationships.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	p.sheetPaths = make(map[string]string, len(workbook.Sheets))
+	for _, sheet := range workbook.Sheets {
+		p.sheetPaths[sheet.Name] = targets[sheet.ID]
+	}
+
+	return nil
+}
+
+func (p *xlsxPackage) openPart(name string) (io.ReadCloser, error) {
+	for _, file := range p.archive.File {
+		if file.Name == name {
+			return file.Open()
+		}
+	}
+	return nil, fmt.Errorf("workbook part %s not found", name)
+}
+
+// partSize is the uncompressed size of a part as recorded in the package.
+func (p *xlsxPackage) partSize(name string) (uint64, bool) {
+	for _, file := range p.archive.File {
+		if file.Name == name {
+			return file.UncompressedSize64, true
+		}
+	}
+	return 0, false
+}
+
+func (p *xlsxPackage) decodePart(name string, v any) error {
+	part, err := p.openPart(name)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	if err := xml.NewDecoder(part).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// sheetLayout scans a sheet's XML for merged regions and formula cells
+// without keeping any cell values.
+func (p *xlsxPackage) sheetLayout(sheetName string) (*xlsxSheetLayout, error) {
+	sheetPath, ok := p.sheetPaths[sheetName]
+	if !ok {
+		return nil, fmt.Errorf("sheet %s not found in workbook package", sheetName)
+	}
+
+	part, err := p.openPart(sheetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	layout := &xlsxSheetLayout{}
+	decoder := xml.NewDecoder(part)
+	var currentCell string
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sheet %s: %w", sheetName, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "c":
+			currentCell = xmlAttr(start, "r")
+		case "f":
+			if currentCell != "" {
+				layout.formulas = append(layout.formulas, currentCell)
+				currentCell = ""
+			}
+		case "mergeCell":
+			if merged, ok := parseMergedRange(xmlAttr(start, "ref")); ok {
+				layout.merges = append(layout.merges, merged)
+			}
+		}
+	}
+
+	return layout, nil
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func parseMergedRange(ref string) (mergedRange, bool) {
+	start, end, found := strings.Cut(ref, ":")
+	if !found {
+		return mergedRange{}, false
+	}
+
+	startCol, startRow, err := excelize.CellNameToCoordinates(start)
+	if err != nil {
+		return mergedRange{}, false
+	}
+	endCol, endRow, err := excelize.CellNameToCoordinates(end)
+	if err != nil {
+		return mergedRange{}, false
+	}
+
+	return mergedRange{startCol: startCol, startRow: startRow, endCol: endCol, endRow: endRow}, true
+}
+
+// streamXlsxRows passes the rows of a sheet to fn one at a time, with the
+// rule's reading options applied: raw instead of formatted values, formulas
+// recalculated instead of their cached results, and merged regions filled
+// with the value of their top-left cell. Rows are not kept, so memory use
+// does not grow with the sheet. rowIndex is zero-based.
+func streamXlsxRows(f *excelize.File, pkg *xlsxPackage, sheetName string, rule Rule, result *ExtractionResult, fn func(rowIndex int, cells []string)) error {
+	opts := excelize.Options{RawCellValue: rule.RawValues}
+
+	layout := &xlsxSheetLayout{}
+	if pkg != nil && (rule.MergedCells || rule.EvaluateFormulas) {
+		scanned, err := pkg.sheetLayout(sheetName)
+		if err != nil {
+			result.addWarning("Layout of sheet %s could not be read: %v", sheetName, err)
+		} else {
+			layout = scanned
+		}
+	}
+
+	var formulaValues map[int]map[int]string
+	if rule.EvaluateFormulas && len(layout.formulas) > 0 {
+		sheetPath := pkg.sheetPaths[sheetName]
+		if size, _ := pkg.partSize(sheetPath); size > maxFormulaSheetSize {
+			result.addWarning("Sheet %s is too large to recalculate its formulas (%d MB), using their cached values",
+				sheetName, size>>20)
+		} else {
+			formulaValues = evaluateFormulas(pkg, sheetName, layout.formulas, opts, result)
+		}
+	}
+
+	rows, err := f.Rows(sheetName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rowIndex := 0; rows.Next(); rowIndex++ {
+		cells, err := rows.Columns(opts)
+		if err != nil {
+			return fmt.Errorf("failed to read row %d: %w", rowIndex+1, err)
+		}
+
+		for col, value := range formulaValues[rowIndex+1] {
+			cells = setRowValue(cells, col-1, value)
+		}
+
+		if rule.MergedCells {
+			cells = fillMergedCells(layout.merges, rowIndex+1, cells)
+		}
+
+		fn(rowIndex, cells)
+	}
+
+	return rows.Error()
+}
+
+// maxFormulaSheetSize is the largest sheet XML whose formulas are
+// recalculated. Calculation parses the whole sheet into memory, which takes
+// about 16 times the size of its XML, so larger sheets are only streamed.
+const maxFormulaSheetSize = 2 << 20
+
+// maxEvaluatedFormulas caps the formula cells recalculated per sheet; the
+// others keep their cached values.
+const maxEvaluatedFormulas = 1000
+
+// evaluateFormulas calculates the listed formula cells and returns their
+// values by row and column number. Workbooks written by other tools often
+// carry no cached values at all, so these cells would otherwise read as
+// empty. Failures and cells past the cap are reported once per sheet.
+//
+// Calculation parses the whole worksheet, so it runs on a separate copy of
+// the workbook that is closed again before the sheet is streamed. Worksheets
+// above maxFormulaSheetSize stay in temporary files in that copy, which keeps
+// sheets referenced by the formulas out of memory as well.
+func evaluateFormulas(pkg *xlsxPackage, sheetName string, cells []string, opts excelize.Options, result *ExtractionResult) map[int]map[int]string {
+	f, err := excelize.OpenFile(pkg.path, excelize.Options{
+		Password:          pkg.password,
+		UnzipXMLSizeLimit: maxFormulaSheetSize,
+	})
+	if err != nil {
+		result.addWarning("Formulas in sheet %s could not be recalculated, using their cached values: %v", sheetName, err)
+		return nil
+	}
+	defer f.Close()
+
+	if len(cells) > maxEvaluatedFormulas {
+		result.addWarning("Sheet %s has %d formulas; only the first %d were recalculated, the others use their cached values",
+			sheetName, len(cells), maxEvaluatedFormulas)
+		cells = cells[:maxEvaluatedFormulas]
+	}
+
+	values := make(map[int]map[int]string)
+	var failed []string
+	var firstErr error
+	for _, cell := range cells {
+		value, err := f.CalcCellValue(sheetName, cell, opts)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed = append(failed, cell)
+			continue
+		}
+
+		col, row, _ := excelize.CellNameToCoordinates(cell)
+		if values[row] == nil {
+			values[row] = make(map[int]string)
+		}
+		values[row][col] = value
+	}
+
+	if len(failed) > 0 {
+		result.addWarning("%d formulas in sheet %s could not be evaluated (first %s: %v), using their cached values",
+			len(failed), sheetName, failed[0], firstErr)
+	}
+
+	return values
+}
+
+// fillMergedCells copies the top-left value of every merged region that
+// covers the row into the region's other cells. Rows arrive in order, so the
+// value is taken when the region's first row passes.
+func fillMergedCells(merges []mergedRange, rowNumber int, cells []string) []string {
+	for i := range merges {
+		merged := &merges[i]
+		if rowNumber < merged.startRow || rowNumber > merged.endRow {
+			continue
+		}
+
+		if rowNumber == merged.startRow && merged.startCol-1 < len(cells) {
+			merged.value = cells[merged.startCol-1]
+		}
+		if merged.value == "" {
+			continue
+		}
+
+		for col := merged.startCol; col <= merged.endCol; col++ {
+			cells = setRowValue(cells, col-1, merged.value)
+		}
+	}
+
+	return cells
+}
+
+func setRowValue(cells []string, colIndex int, value string) []string {
+	for len(cells) <= colIndex {
+		cells = append(cells, "")
+	}
+	cells[colIndex] = value
+	return cells
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestStreamXlsxRows_Options(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	// The insurer name spans two rows of a merged block.
+	f.SetCellValue("Sheet1", "A1", DefaultTargetText)
+	f.MergeCell("Sheet1", "A1", "A2")
+	f.SetCellValue("Sheet1", "B1", "228960453")
+	f.SetCellValue("Sheet1", "C1", "123")
+	f.SetCellValue("Sheet1", "B2", "228209382")
+	f.SetCellFormula("Sheet1", "C2", "100+356")
+
+	style, _ := f.NewStyle(&excelize.Style{CustomNumFmt: stringPtr("00000")})
+	f.SetCellValue("Sheet1", "D1", 42)
+	f.SetCellStyle("Sheet1", "D1", "D1", style)
+
+	testFile := filepath.Join(t.TempDir(), "options.xlsx")
+	if err := f.SaveAs(testFile); err != nil {
+		t.Fatalf("Failed to save test file: %v", err)
+	}
+
+	t.Run("plain rows", func(t *testing.T) {
+		rows := collectXlsxRows(t, testFile, Rule{})
+		if gridValue(rows, 1, 0) != "" || gridValue(rows, 1, 2) != "" {
+			t.Errorf("Without options merged and formula cells should be empty, got %v", rows)
+		}
+		if gridValue(rows, 0, 3) != "00042" {
+			t.Errorf("Formatted value = %q, want %q", gridValue(rows, 0, 3), "00042")
+		}
+	})
+
+	t.Run("with options", func(t *testing.T) {
+		rows := collectXlsxRows(t, testFile, Rule{MergedCells: true, EvaluateFormulas: true, RawValues: true})
+		if gridValue(rows, 1, 0) != DefaultTargetText {
+			t.Errorf("Merged value should be propagated, got %q", gridValue(rows, 1, 0))
+		}
+		if gridValue(rows, 1, 2) != "456" {
+			t.Errorf("Formula value = %q, want %q", gridValue(rows, 1, 2), "456")
+		}
+		if gridValue(rows, 0, 3) != "42" {
+			t.Errorf("Raw value = %q, want %q", gridValue(rows, 0, 3), "42")
+		}
+	})
+
+	t.Run("rule options match both merged rows", func(t *testing.T) {
+		h := NewHandlerWithConfig(Config{})
+		rule := h.defaultRule()
+		rule.MergedCells, rule.EvaluateFormulas = true, true
+		result, err := h.readExcelFile(testFile, rule)
+		if err != nil {
+			t.Fatalf("readExcelFile failed: %v", err)
+		}
+		contracts := result.Contracts()
+		if len(contracts) != 2 || contracts[1] != "228209382-456" {
+			t.Errorf("Expected both rows of the merged block, got %v", contracts)
+		}
+	})
+}
+
+// collectXlsxRows streams the first sheet of a workbook into a grid.
+func collectXlsxRows(t *testing.T, path string, rule Rule) [][]string {
+	t.Helper()
+
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Failed to open test file: %v", err)
+	}
+	defer f.Close()
+
+	pkg, err := openXlsxPackage(path, "")
+	if err != nil {
+		t.Fatalf("openXlsxPackage failed: %v", err)
+	}
+	defer pkg.Close()
+
+	var rows [][]string
+	err = streamXlsxRows(f, pkg, "Sheet1", rule, &ExtractionResult{}, func(rowIndex int, cells []string) {
+		rows = append(rows, cells)
+	})
+	if err != nil {
+		t.Fatalf("streamXlsxRows failed: %v", err)
+	}
+	return rows
+}
+
+func TestEvaluateFormulas_Limits(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	var cells []string
+	for row := 1; row <= maxEvaluatedFormulas+5; row++ {
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		formula := "1+1"
+		if row <= 3 {
+			formula = "UNKNOWNFUNC(1)"
+		}
+		f.SetCellFormula("Sheet1", cell, formula)
+		cells = append(cells, cell)
+	}
+
+	path := filepath.Join(t.TempDir(), "formulas.xlsx")
+	if err := f.SaveAs(path); err != nil {
+		t.Fatalf("Failed to save test file: %v", err)
+	}
+	pkg, err := openXlsxPackage(path, "")
+	if err != nil {
+		t.Fatalf("openXlsxPackage failed: %v", err)
+	}
+	defer pkg.Close()
+
+	result := &ExtractionResult{}
+	values := evaluateFormulas(pkg, "Sheet1", cells, excelize.Options{}, result)
+
+	if len(values) != maxEvaluatedFormulas-3 {
+		t.Errorf("evaluated %d cells, want %d", len(values), maxEvaluatedFormulas-3)
+	}
+	if len(result.Warnings) != 2 {
+		t.Errorf("expected one warning for the cap and one for the failures, got %v", result.Warnings)
+	}
+}
+
+func TestEvaluateFormulas_EncryptedWorkbook(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetCellValue("Sheet1", "A1", DefaultTargetText)
+	f.SetCellValue("Sheet1", "B1", "228960453")
+	f.SetCellFormula("Sheet1", "C1", "100+23")
+
+	path := filepath.Join(t.TempDir(), "protected.xlsx")
+	if err := f.SaveAs(path, excelize.Options{Password: "secret"}); err != nil {
+		t.Fatalf("failed to save encrypted workbook: %v", err)
+	}
+
+	h := NewHandler()
+	rule := h.defaultRule()
+	rule.EvaluateFormulas = true
+	result, err := h.readXlsxFile(path, rule, "secret")
+	if err != nil {
+		t.Fatalf("readXlsxFile failed: %v", err)
+	}
+	if contracts := result.Contracts(); len(contracts) != 1 || contracts[0] != "228960453-123" {
+		t.Errorf("expected the recalculated contract, got %v (warnings %v)", contracts, result.Warnings)
+	}
+}
+
+func gridValue(rows [][]string, rowIndex, colIndex int) string {
+	if rowIndex >= len(rows) || colIndex >= len(rows[rowIndex]) {
+		return ""
+	}
+	return rows[rowIndex][colIndex]
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+const largeWorkbookRows = 100000
+
+// writeLargeWorkbook generates a register like the production ones: every
+// 100th row belongs to the target insurer, and the last column is a formula.
+func writeLargeWorkbook(tb testing.TB, rows int) string {
+	tb.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		tb.Fatalf("NewStreamWriter failed: %v", err)
+	}
+
+	for i := 1; i <= rows; i++ {
+		insurer := "ІНШИЙ СТРАХОВИК"
+		if i%100 == 0 {
+			insurer = DefaultTargetText
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, i)
+		premium := excelize.Cell{Formula: fmt.Sprintf("G%d*2", i)}
+		row := []any{i, insurer, 228000000 + i, i % 1000, "Іваненко Іван Іванович", "2024-01-01", 1234.56, premium}
+		if err := sw.SetRow(cell, row); err != nil {
+			tb.Fatalf("SetRow failed: %v", err)
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		tb.Fatalf("Flush failed: %v", err)
+	}
+
+	path := filepath.Join(tb.TempDir(), "large.xlsx")
+	if err := f.SaveAs(path); err != nil {
+		tb.Fatalf("Failed to save large workbook: %v", err)
+	}
+	return path
+}
+
+func BenchmarkReadXlsxFile_Large(b *testing.B) {
+	path := writeLargeWorkbook(b, largeWorkbookRows)
+	h := &Handler{}
+	rule := h.defaultRule()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := h.readXlsxFile(path, rule, "")
+		if err != nil {
+			b.Fatalf("readXlsxFile failed: %v", err)
+		}
+		if len(result.Matches) != largeWorkbookRows/100 {
+			b.Fatalf("Expected %d matches, got %d", largeWorkbookRows/100, len(result.Matches))
+		}
+	}
+}
+
+// TestReadXlsxFile_LargeMemory guards against going back to loading whole
+// sheets: the peak heap while reading a 100k-row register must stay far
+// below the container's memory limit, also when the rule recalculates
+// formulas.
+func TestReadXlsxFile_LargeMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large workbook test in short mode")
+	}
+
+	path := writeLargeWorkbook(t, largeWorkbookRows)
+	h := &Handler{}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	go func() {
+		var stats runtime.MemStats
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&stats)
+				if stats.HeapInuse > peak.Load() {
+					peak.Store(stats.HeapInuse)
+				}
+			}
+		}
+	}()
+
+	rule := h.defaultRule()
+	rule.MergedCells, rule.EvaluateFormulas = true, true
+
+	result, err := h.readXlsxFile(path, rule, "")
+	close(done)
+	if err != nil {
+		t.Fatalf("readXlsxFile failed: %v", err)
+	}
+	if len(result.Matches) != largeWorkbookRows/100 {
+		t.Fatalf("Expected %d matches, got %d", largeWorkbookRows/100, len(result.Matches))
+	}
+
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "too large to recalculate") {
+		t.Errorf("Expected a warning that formulas were not recalculated, got %v", result.Warnings)
+	}
+
+	growth := int64(peak.Load()) - int64(before.HeapInuse)
+	t.Logf("Peak heap growth while reading %d rows: %d MB", largeWorkbookRows, growth>>20)
+	if limit := int64(64 << 20); growth > limit {
+		t.Errorf("Reading used %d MB of heap, want at most %d MB", growth>>20, limit>>20)
+	}
+}